@@ -25,7 +25,16 @@ import (
 
 // ServicePort contains information on service's port.
 type ServicePort struct {
+	// Hosts are the IP addresses or DNS names of the backends. DNS names are resolved
+	// every probe period, and each of their A and AAAA records is probed and published.
+	// A host listening on another port than the targetPort is given as host:port, or [host]:port
+	// for IPv6 addresses, it is probed and published on that port. An IPv6 address in brackets needs the port.
+	// +kubebuilder:validation:items:Pattern=`^([^\[].*|\[[0-9A-Fa-f:.]+\]:[0-9]+)$`
 	Hosts []string `json:"hosts,omitempty" patchStrategy:"merge" patchMergeKey:"host" protobuf:"bytes,3,rep,name=hosts"`
+	// BackupHosts are probed like the hosts, but only published while none of the hosts is healthy.
+	// +optional
+	// +kubebuilder:validation:items:Pattern=`^([^\[].*|\[[0-9A-Fa-f:.]+\]:[0-9]+)$`
+	BackupHosts []string `json:"backupHosts,omitempty" protobuf:"bytes,14,rep,name=backupHosts"`
 	// The action taken to determine the health of a container
 	Handler `json:",inline" protobuf:"bytes,1,opt,name=handler"`
 	// Number of seconds after which the probe times out.
@@ -57,12 +66,74 @@ type ServicePort struct {
 	// The port that will be exposed by this service.
 	Port int32 `json:"port" protobuf:"varint,8,opt,name=port"`
 
-	// Number or name of the port to access on the pods targeted by the service.
-	// Number must be in the range 1 to 65535. Name must be an IANA_SVC_NAME.
-	// If this is a string, it will be looked up as a named port in the
-	// target Pod's container ports. If this is not specified, the value
-	// of the 'port' field is used (an identity map).
+	// Number of the port to access on the hosts, in the range 1 to 65535.
+	// Hosts given as host:port override it. The Service refers to it by the name of the port,
+	// so that the hosts of a port do not need to listen on the same number.
+	// Named target ports are not supported, the name of the port already is the targetPort of the Service.
+	// A name is rejected here, and reported in the SyncEndpointReady condition when given in host:port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
 	TargetPort int32 `json:"targetPort" protobuf:"varint,10,opt,name=targetPort"`
+	// Certificate checks the TLS certificate presented by the hosts, besides their probe.
+	// +optional
+	Certificate *CertificateCheck `json:"certificate,omitempty" protobuf:"bytes,11,opt,name=certificate"`
+	// Checks are run against every host instead of the handler of the port, each with its own timeout.
+	// +optional
+	Checks []Check `json:"checks,omitempty" patchStrategy:"merge" patchMergeKey:"name" protobuf:"bytes,12,rep,name=checks"`
+	// CheckPolicy decides how the results of the checks are combined. All needs every check to
+	// succeed, Any needs at least one of them. Defaults to All.
+	// +optional
+	// +kubebuilder:validation:Enum=All;Any
+	CheckPolicy CheckPolicy `json:"checkPolicy,omitempty" protobuf:"bytes,13,opt,name=checkPolicy,casttype=CheckPolicy"`
+	// FlapDetection holds a host out of the endpoints once its health changed too often.
+	// +optional
+	FlapDetection *FlapDetection `json:"flapDetection,omitempty" protobuf:"bytes,15,opt,name=flapDetection"`
+	// MinReadySeconds is how long a host that failed has to stay healthy before it is published again.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty" protobuf:"varint,16,opt,name=minReadySeconds"`
+	// NodePort is the port on each node the port is exposed on when the type is NodePort or LoadBalancer.
+	// Allocated by the system when unset.
+	// +optional
+	NodePort int32 `json:"nodePort,omitempty" protobuf:"varint,17,opt,name=nodePort"`
+}
+
+// FlapDetection decides when a host is flapping.
+type FlapDetection struct {
+	// MaxTransitions is how many times the health of a host may change within the window.
+	// A host that changes more often is flapping.
+	// +kubebuilder:validation:Minimum=1
+	MaxTransitions int32 `json:"maxTransitions" protobuf:"varint,1,opt,name=maxTransitions"`
+	// WindowSeconds is the window the transitions are counted in. Defaults to 300 seconds.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	WindowSeconds int32 `json:"windowSeconds,omitempty" protobuf:"varint,2,opt,name=windowSeconds"`
+	// StableSeconds is how long a flapping host has to stay healthy before it is published again.
+	// Defaults to the windowSeconds.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	StableSeconds int32 `json:"stableSeconds,omitempty" protobuf:"varint,3,opt,name=stableSeconds"`
+}
+
+type CheckPolicy string
+
+const (
+	// CheckPolicyAll needs every check to succeed.
+	CheckPolicyAll CheckPolicy = "All"
+	// CheckPolicyAny needs at least one check to succeed.
+	CheckPolicyAny CheckPolicy = "Any"
+)
+
+// Check is one of the checks of a port.
+type Check struct {
+	// Name identifies the check in the status and the metrics.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+	// The action taken to determine the health of the host
+	Handler `json:",inline" protobuf:"bytes,2,opt,name=handler"`
+	// Number of seconds after which the check times out.
+	// Defaults to the timeoutSeconds of the port.
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty" protobuf:"varint,3,opt,name=timeoutSeconds"`
 }
 
 func (sp *ServicePort) ToEndpointSubset(host string) v1.EndpointSubset {
@@ -83,9 +154,25 @@ func (sp *ServicePort) ToEndpointSubset(host string) v1.EndpointSubset {
 	}
 }
 
+// ToNotReadyEndpointSubset is like ToEndpointSubset but puts the host into NotReadyAddresses.
+func (sp *ServicePort) ToNotReadyEndpointSubset(host string) v1.EndpointSubset {
+	subset := sp.ToEndpointSubset(host)
+	subset.NotReadyAddresses, subset.Addresses = subset.Addresses, nil
+	return subset
+}
+
 // TCPSocketAction describes an action based on opening a socket
 type TCPSocketAction struct {
 	Enable bool `json:"enable" protobuf:"bytes,1,opt,name=enable"`
+	// Send is written to the connection once it is open.
+	// +optional
+	Send string `json:"send,omitempty" protobuf:"bytes,2,opt,name=send"`
+	// ExpectRegex must match the data read from the connection within the timeout.
+	// +optional
+	ExpectRegex string `json:"expectRegex,omitempty" protobuf:"bytes,3,opt,name=expectRegex"`
+	// ExpectPrefix must be the beginning of the data read from the connection within the timeout.
+	// +optional
+	ExpectPrefix string `json:"expectPrefix,omitempty" protobuf:"bytes,4,opt,name=expectPrefix"`
 }
 
 // UDPSocketAction describes an action based on opening a socket
@@ -94,6 +181,29 @@ type UDPSocketAction struct {
 	// UDP test data
 	// +optional
 	Data []uint8 `json:"data,omitempty" protobuf:"varint,2,rep,name=data"`
+	// ExpectPrefix must be the beginning of the response datagram.
+	// +optional
+	ExpectPrefix []uint8 `json:"expectPrefix,omitempty" protobuf:"varint,3,rep,name=expectPrefix"`
+	// ExpectRegex must match the response datagram.
+	// +optional
+	ExpectRegex string `json:"expectRegex,omitempty" protobuf:"bytes,4,opt,name=expectRegex"`
+	// MinLength is the minimum length of the response datagram.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinLength int32 `json:"minLength,omitempty" protobuf:"varint,5,opt,name=minLength"`
+	// DNS sends a DNS query instead of the data, and expects a NOERROR response.
+	// +optional
+	DNS *DNSQuery `json:"dns,omitempty" protobuf:"bytes,6,opt,name=dns"`
+}
+
+// DNSQuery is the question of a DNS probe.
+type DNSQuery struct {
+	// Name is the queried domain name.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+	// Type is the queried record type, A by default.
+	// +optional
+	// +kubebuilder:validation:Enum=A;AAAA;CNAME;MX;NS;PTR;SOA;SRV;TXT
+	Type string `json:"type,omitempty" protobuf:"bytes,2,opt,name=type"`
 }
 
 func Int8ArrToByteArr(data []uint8) []byte {
@@ -104,7 +214,7 @@ func Int8ArrToByteArr(data []uint8) []byte {
 	return r
 }
 
-// HTTPGetAction describes an action based on HTTP Get requests.
+// HTTPGetAction describes an action based on HTTP requests.
 type HTTPGetAction struct {
 	// Path to access on the HTTP server.
 	// +optional
@@ -116,6 +226,49 @@ type HTTPGetAction struct {
 	// Custom headers to set in the request. HTTP allows repeated headers.
 	// +optional
 	HTTPHeaders []v1.HTTPHeader `json:"httpHeaders,omitempty" protobuf:"bytes,5,rep,name=httpHeaders"`
+	// Method of the request. Defaults to GET.
+	// +optional
+	// +kubebuilder:validation:Enum=GET;HEAD;POST
+	Method string `json:"method,omitempty" protobuf:"bytes,6,opt,name=method"`
+	// Body of the request.
+	// +optional
+	Body string `json:"body,omitempty" protobuf:"bytes,7,opt,name=body"`
+	// ExpectedStatuses are the status codes that are treated as healthy, either a single
+	// code such as "200" or an inclusive range such as "200-299".
+	// Defaults to 200-399.
+	// +optional
+	ExpectedStatuses []string `json:"expectedStatuses,omitempty" protobuf:"bytes,8,rep,name=expectedStatuses"`
+	// ResponseBody asserts on the body of the response.
+	// +optional
+	ResponseBody *HTTPResponseBody `json:"responseBody,omitempty" protobuf:"bytes,9,opt,name=responseBody"`
+	// TLS configures the connection when the scheme is HTTPS.
+	// Without it the certificate of the server is not verified.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty" protobuf:"bytes,10,opt,name=tls"`
+}
+
+// HTTPResponseBody describes the assertions on the body of an HTTP response.
+// All the assertions that are set must hold.
+type HTTPResponseBody struct {
+	// Contains is a substring the body must contain.
+	// +optional
+	Contains string `json:"contains,omitempty" protobuf:"bytes,1,opt,name=contains"`
+	// Regex is a regular expression the body must match.
+	// +optional
+	Regex string `json:"regex,omitempty" protobuf:"bytes,2,opt,name=regex"`
+	// JSONPath is evaluated against the body parsed as JSON, for example {.status}.
+	// The result must equal Value when Value is set, and must not be empty otherwise.
+	// +optional
+	JSONPath string `json:"jsonPath,omitempty" protobuf:"bytes,3,opt,name=jsonPath"`
+	// Value is the expected result of JSONPath.
+	// +optional
+	Value string `json:"value,omitempty" protobuf:"bytes,4,opt,name=value"`
+	// MaxBytes is how much of the body is read, the rest is ignored.
+	// Defaults to 10240. Maximum value is 1048576.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1048576
+	MaxBytes int32 `json:"maxBytes,omitempty" protobuf:"varint,5,opt,name=maxBytes"`
 }
 
 type GRPCAction struct {
@@ -127,10 +280,116 @@ type GRPCAction struct {
 	// +optional
 	// +default=""
 	Service *string `json:"service" protobuf:"bytes,2,opt,name=service"`
+	// TLS enables TLS on the connection, it is plaintext otherwise.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty" protobuf:"bytes,3,opt,name=tls"`
+}
+
+// TLSConfig describes how a probe connects over TLS.
+// The Secrets and ConfigMaps are read from the namespace of the ClusterEndpoint,
+// they need the label sealos.io/probe-material=true.
+type TLSConfig struct {
+	// CA is the bundle of certificates used to verify the server.
+	// Defaults to the system roots.
+	// +optional
+	CA *TLSSelector `json:"ca,omitempty" protobuf:"bytes,1,opt,name=ca"`
+	// Cert is the client certificate for mutual TLS, KeySecret must be set with it.
+	// +optional
+	Cert *TLSSelector `json:"cert,omitempty" protobuf:"bytes,2,opt,name=cert"`
+	// KeySecret is the Secret key holding the private key of the client certificate.
+	// +optional
+	KeySecret *v1.SecretKeySelector `json:"keySecret,omitempty" protobuf:"bytes,3,opt,name=keySecret"`
+	// ServerName is used to verify the hostname of the server and is sent as SNI.
+	// Defaults to the host when it is a DNS name.
+	// +optional
+	ServerName string `json:"serverName,omitempty" protobuf:"bytes,4,opt,name=serverName"`
+	// InsecureSkipVerify disables the verification of the certificate of the server.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" protobuf:"varint,5,opt,name=insecureSkipVerify"`
+	// MinVersion is the minimum TLS version. Defaults to TLS12.
+	// +optional
+	// +kubebuilder:validation:Enum=TLS10;TLS11;TLS12;TLS13
+	MinVersion string `json:"minVersion,omitempty" protobuf:"bytes,6,opt,name=minVersion"`
+}
+
+// TLSSelector refers to a key of a Secret or of a ConfigMap, only one of them may be set.
+type TLSSelector struct {
+	// Secret containing the data.
+	// +optional
+	Secret *v1.SecretKeySelector `json:"secret,omitempty" protobuf:"bytes,1,opt,name=secret"`
+	// ConfigMap containing the data.
+	// +optional
+	ConfigMap *v1.ConfigMapKeySelector `json:"configMap,omitempty" protobuf:"bytes,2,opt,name=configMap"`
+}
+
+// MySQLAction describes an action based on the MySQL handshake.
+type MySQLAction struct {
+	Enable bool `json:"enable" protobuf:"bytes,1,opt,name=enable"`
+}
+
+// PostgreSQLAction describes an action based on the PostgreSQL startup message.
+type PostgreSQLAction struct {
+	Enable bool `json:"enable" protobuf:"bytes,1,opt,name=enable"`
+	// TLS requires the server to accept the SSLRequest, and verifies it with these settings.
+	// Without it the connection is upgraded when the server offers TLS, without verifying it.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty" protobuf:"bytes,2,opt,name=tls"`
+}
+
+// RedisAction describes an action based on the Redis PING command.
+type RedisAction struct {
+	Enable bool `json:"enable" protobuf:"bytes,1,opt,name=enable"`
+	// Username is sent with AUTH when PasswordSecret is set, for servers with ACLs.
+	// +optional
+	Username string `json:"username,omitempty" protobuf:"bytes,2,opt,name=username"`
+	// PasswordSecret is the Secret key holding the password sent with AUTH before PING.
+	// The Secret needs the label sealos.io/probe-material=true.
+	// +optional
+	PasswordSecret *v1.SecretKeySelector `json:"passwordSecret,omitempty" protobuf:"bytes,3,opt,name=passwordSecret"`
+}
+
+// CertificateCheck describes the check of the TLS certificate presented by the hosts of a port.
+// The TLS config of the HTTP or gRPC handler is used for the handshake when set.
+type CertificateCheck struct {
+	Enable bool `json:"enable" protobuf:"bytes,1,opt,name=enable"`
+	// MinValidDays is the number of days the certificate must still be valid for.
+	// The certificate is only recorded when it is 0.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinValidDays int32 `json:"minValidDays,omitempty" protobuf:"varint,2,opt,name=minValidDays"`
+	// ExpiryAction decides what happens when the certificate is valid for less than MinValidDays.
+	// Warn raises a Warning event, Fail also marks the host unhealthy. Defaults to Warn.
+	// +optional
+	// +kubebuilder:validation:Enum=Warn;Fail
+	ExpiryAction CertificateExpiryAction `json:"expiryAction,omitempty" protobuf:"bytes,3,opt,name=expiryAction,casttype=CertificateExpiryAction"`
+}
+
+type CertificateExpiryAction string
+
+const (
+	// CertificateExpiryActionWarn raises a Warning event for certificates about to expire.
+	CertificateExpiryActionWarn CertificateExpiryAction = "Warn"
+	// CertificateExpiryActionFail marks the hosts with certificates about to expire unhealthy.
+	CertificateExpiryActionFail CertificateExpiryAction = "Fail"
+)
+
+// ExecAction describes a command that is run inside the operator container.
+type ExecAction struct {
+	// Command is the command line to execute. The command is simply exec'd, it is
+	// not run inside a shell, so traditional shell instructions ('|', etc) won't work.
+	// To use a shell, you need to explicitly call out to that shell.
+	// The host and the target port are passed in the HOST and PORT environment variables.
+	// Exit status of 0 is treated as healthy and non-zero is unhealthy.
+	// +optional
+	Command []string `json:"command,omitempty" protobuf:"bytes,1,rep,name=command"`
 }
 
 // Handler defines a specific action that should be taken
 type Handler struct {
+	// Exec specifies a command to run inside the operator container.
+	// Exec probes are refused unless the operator is started with --enable-exec-probe.
+	// +optional
+	Exec *ExecAction `json:"exec,omitempty" protobuf:"bytes,6,opt,name=exec"`
 	// HTTPGet specifies the http request to perform.
 	// +optional
 	HTTPGet *HTTPGetAction `json:"httpGet,omitempty" protobuf:"bytes,2,opt,name=httpGet"`
@@ -147,6 +406,17 @@ type Handler struct {
 	// This is an alpha field and requires enabling GRPCContainerProbe feature gate.
 	// +optional
 	GRPC *GRPCAction `json:"grpc,omitempty" protobuf:"bytes,5,opt,name=grpc"`
+
+	// MySQL checks that the server sends its handshake packet, and not an error such as too many connections.
+	// +optional
+	MySQL *MySQLAction `json:"mysql,omitempty" protobuf:"bytes,7,opt,name=mysql"`
+	// PostgreSQL checks that the server answers a startup message with an authentication request,
+	// and not an error such as the database system is starting up.
+	// +optional
+	PostgreSQL *PostgreSQLAction `json:"postgresql,omitempty" protobuf:"bytes,8,opt,name=postgresql"`
+	// Redis checks that the server answers PING with PONG.
+	// +optional
+	Redis *RedisAction `json:"redis,omitempty" protobuf:"bytes,9,opt,name=redis"`
 }
 
 // ClusterEndpointSpec defines the desired state of ClusterEndpoint
@@ -157,8 +427,148 @@ type ClusterEndpointSpec struct {
 	// Default to 10 seconds. Minimum value is 1.
 	// +optional
 	PeriodSeconds int32 `json:"periodSeconds,omitempty" protobuf:"varint,4,opt,name=periodSeconds"`
+	// NotReadyPolicy decides what happens to the hosts that fail their probe.
+	// Drop removes them from the Endpoints, NotReadyAddresses keeps them in the
+	// notReadyAddresses of the Endpoints. Defaults to Drop.
+	// +optional
+	// +kubebuilder:validation:Enum=Drop;NotReadyAddresses
+	NotReadyPolicy NotReadyPolicy `json:"notReadyPolicy,omitempty" protobuf:"bytes,5,opt,name=notReadyPolicy,casttype=NotReadyPolicy"`
+	// EndpointMode decides which resources are generated for the hosts.
+	// Endpoints writes the legacy core/v1 Endpoints, EndpointSlice writes discovery.k8s.io/v1
+	// EndpointSlices and Both writes the two of them. Defaults to Endpoints.
+	// +optional
+	// +kubebuilder:validation:Enum=Endpoints;EndpointSlice;Both
+	EndpointMode EndpointMode `json:"endpointMode,omitempty" protobuf:"bytes,6,opt,name=endpointMode,casttype=EndpointMode"`
+	// MinHealthyPercent is the panic threshold. When less than this percentage of the addresses
+	// of the hosts is healthy, the probe results are ignored and the hosts are published as the
+	// PanicPolicy says, so that a problem of the operator itself does not cut off every client.
+	// Disabled when unset or 0.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MinHealthyPercent int32 `json:"minHealthyPercent,omitempty" protobuf:"varint,7,opt,name=minHealthyPercent"`
+	// PanicPolicy decides what is published below the MinHealthyPercent. PublishAll publishes
+	// every host as ready, LastKnownGood keeps what was published before. Defaults to PublishAll.
+	// +optional
+	// +kubebuilder:validation:Enum=PublishAll;LastKnownGood
+	PanicPolicy PanicPolicy `json:"panicPolicy,omitempty" protobuf:"bytes,8,opt,name=panicPolicy,casttype=PanicPolicy"`
+	// DrainedHosts are hosts or addresses that are kept out of the endpoints of every port,
+	// while they are still probed. The DrainAnnotation drains hosts as well.
+	// +optional
+	DrainedHosts []string `json:"drainedHosts,omitempty" protobuf:"bytes,9,rep,name=drainedHosts"`
+	// Paused stops writing the Service and the Endpoints, the hosts are still probed for the status.
+	// The PausedAnnotation pauses the ClusterEndpoint as well.
+	// +optional
+	Paused bool `json:"paused,omitempty" protobuf:"varint,10,opt,name=paused"`
+	// AdoptionPolicy decides what happens to a Service and Endpoints that exist already.
+	// Adopt takes them over and becomes their controller, Merge only writes the ports and the
+	// addresses and leaves everything else, owner references included, to whoever manages them,
+	// FailIfExists refuses to write objects the ClusterEndpoint does not control. Fields the
+	// operator does not write are kept with every policy. Defaults to Adopt.
+	// +optional
+	// +kubebuilder:validation:Enum=Adopt;Merge;FailIfExists
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty" protobuf:"bytes,11,opt,name=adoptionPolicy,casttype=AdoptionPolicy"`
+	// DeletionPolicy decides what happens to the generated resources when the ClusterEndpoint is deleted.
+	// Delete lets them be garbage collected with it, Orphan removes their owner references and leaves
+	// them in place. Defaults to Delete.
+	// +optional
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty" protobuf:"bytes,12,opt,name=deletionPolicy,casttype=DeletionPolicy"`
+
+	// The following fields are copied to the Service.
+
+	// ClusterIPs are the IP addresses of the Service, one per IP family. The first one must match the clusterIP.
+	// +optional
+	ClusterIPs []string `json:"clusterIPs,omitempty" protobuf:"bytes,13,rep,name=clusterIPs"`
+	// Type of the Service, ClusterIP, NodePort or LoadBalancer. Defaults to ClusterIP.
+	// +optional
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	Type v1.ServiceType `json:"type,omitempty" protobuf:"bytes,14,opt,name=type,casttype=k8s.io/api/core/v1.ServiceType"`
+	// SessionAffinity of the Service, ClientIP or None. Defaults to None.
+	// +optional
+	// +kubebuilder:validation:Enum=ClientIP;None
+	SessionAffinity v1.ServiceAffinity `json:"sessionAffinity,omitempty" protobuf:"bytes,15,opt,name=sessionAffinity,casttype=k8s.io/api/core/v1.ServiceAffinity"`
+	// SessionAffinityConfig contains the timeout of the ClientIP session affinity.
+	// +optional
+	SessionAffinityConfig *v1.SessionAffinityConfig `json:"sessionAffinityConfig,omitempty" protobuf:"bytes,16,opt,name=sessionAffinityConfig"`
+	// IPFamilies of the Service. When empty, a new Service gets the default family of the cluster, and an existing
+	// Service keeps its primary family: the other family of the IP address hosts is appended as PreferDualStack.
+	// +optional
+	IPFamilies []v1.IPFamily `json:"ipFamilies,omitempty" protobuf:"bytes,17,rep,name=ipFamilies,casttype=k8s.io/api/core/v1.IPFamily"`
+	// IPFamilyPolicy of the Service, SingleStack, PreferDualStack or RequireDualStack.
+	// +optional
+	IPFamilyPolicy *v1.IPFamilyPolicy `json:"ipFamilyPolicy,omitempty" protobuf:"bytes,18,opt,name=ipFamilyPolicy,casttype=k8s.io/api/core/v1.IPFamilyPolicy"`
+	// InternalTrafficPolicy of the Service, Cluster or Local.
+	// +optional
+	InternalTrafficPolicy *v1.ServiceInternalTrafficPolicy `json:"internalTrafficPolicy,omitempty" protobuf:"bytes,19,opt,name=internalTrafficPolicy"`
+	// ExternalTrafficPolicy of the Service, Cluster or Local, for NodePort and LoadBalancer Services.
+	// +optional
+	ExternalTrafficPolicy v1.ServiceExternalTrafficPolicy `json:"externalTrafficPolicy,omitempty" protobuf:"bytes,20,opt,name=externalTrafficPolicy"`
+	// LoadBalancerSourceRanges restricts the clients of a LoadBalancer Service.
+	// +optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty" protobuf:"bytes,21,rep,name=loadBalancerSourceRanges"`
 }
 
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the generated resources along with the ClusterEndpoint.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan leaves the generated resources in place.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyAdopt takes existing objects over.
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	// AdoptionPolicyMerge only writes the ports and the addresses of existing objects.
+	AdoptionPolicyMerge AdoptionPolicy = "Merge"
+	// AdoptionPolicyFailIfExists refuses to write existing objects the ClusterEndpoint does not control.
+	AdoptionPolicyFailIfExists AdoptionPolicy = "FailIfExists"
+)
+
+const (
+	// DrainAnnotation is a comma separated list of hosts that are drained like the spec.drainedHosts,
+	// without changing the generation of the ClusterEndpoint.
+	DrainAnnotation = "sealos.io/drain"
+	// PausedAnnotation pauses the ClusterEndpoint like the spec.paused when it is "true".
+	PausedAnnotation = "sealos.io/paused"
+	// ProbeMaterialLabel has to be "true" on the Secrets and ConfigMaps referred to by the probes,
+	// the operator only caches and reads the labeled ones.
+	ProbeMaterialLabel = "sealos.io/probe-material"
+)
+
+type PanicPolicy string
+
+const (
+	// PanicPolicyPublishAll publishes every host as ready.
+	PanicPolicyPublishAll PanicPolicy = "PublishAll"
+	// PanicPolicyLastKnownGood keeps the hosts that were published before.
+	PanicPolicyLastKnownGood PanicPolicy = "LastKnownGood"
+)
+
+type NotReadyPolicy string
+
+const (
+	// NotReadyPolicyDrop removes the failing hosts from the Endpoints.
+	NotReadyPolicyDrop NotReadyPolicy = "Drop"
+	// NotReadyPolicyNotReadyAddresses publishes the failing hosts as notReadyAddresses.
+	NotReadyPolicyNotReadyAddresses NotReadyPolicy = "NotReadyAddresses"
+)
+
+type EndpointMode string
+
+const (
+	// EndpointModeEndpoints only writes the Endpoints.
+	EndpointModeEndpoints EndpointMode = "Endpoints"
+	// EndpointModeEndpointSlice only writes the EndpointSlices.
+	EndpointModeEndpointSlice EndpointMode = "EndpointSlice"
+	// EndpointModeBoth writes the Endpoints and the EndpointSlices.
+	EndpointModeBoth EndpointMode = "Both"
+)
+
 type Phase string
 
 // These are the valid phases of node.
@@ -178,6 +588,15 @@ const (
 	SyncEndpointReady ConditionType = "SyncEndpointReady"
 	Initialized       ConditionType = "Initialized"
 	Ready             ConditionType = "Ready"
+
+	// SyncEndpointSliceReady is only reported when EndpointSlices are written.
+	SyncEndpointSliceReady ConditionType = "SyncEndpointSliceReady"
+	// HostsResolved is only reported when some hosts are DNS names.
+	HostsResolved ConditionType = "HostsResolved"
+	// Degraded is only reported while less than minHealthyPercent of the hosts is healthy.
+	Degraded ConditionType = "Degraded"
+	// Paused is only reported while the ClusterEndpoint is paused.
+	Paused ConditionType = "Paused"
 )
 
 type Condition struct {
@@ -198,12 +617,98 @@ type Condition struct {
 	Message string `json:"message,omitempty" protobuf:"bytes,6,opt,name=message"`
 }
 
+// HostStatus is the observed health of a single host of a port.
+type HostStatus struct {
+	// PortName is the name of the port the host belongs to.
+	// +optional
+	PortName string `json:"portName,omitempty" protobuf:"bytes,1,opt,name=portName"`
+	// TargetPort is the port of the host that is probed.
+	TargetPort int32 `json:"targetPort" protobuf:"varint,2,opt,name=targetPort"`
+	// Host is the address of the backend, without the port it overrides the targetPort with.
+	Host string `json:"host" protobuf:"bytes,3,opt,name=host"`
+	// Ready is true when the host is published as ready in the endpoints.
+	Ready bool `json:"ready" protobuf:"varint,4,opt,name=ready"`
+	// Healthy is true when the host passes its checks, whether it is published or not.
+	// A healthy host that is not ready is a backup host, drained, flapping or within its minReadySeconds.
+	// +optional
+	Healthy bool `json:"healthy,omitempty" protobuf:"varint,16,opt,name=healthy"`
+	// LastProbeTime is the last time the host was probed.
+	// +optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty" protobuf:"bytes,5,opt,name=lastProbeTime"`
+	// LastTransitionTime is the last time the host changed from ready to not ready or back.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty" protobuf:"bytes,6,opt,name=lastTransitionTime"`
+	// ConsecutiveFailures is the number of probes that failed in a row.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty" protobuf:"varint,7,opt,name=consecutiveFailures"`
+	// LastError is the output of the last failed probe.
+	// +optional
+	LastError string `json:"lastError,omitempty" protobuf:"bytes,8,opt,name=lastError"`
+	// LastProbeLatency is how long the last probe took.
+	// +optional
+	LastProbeLatency metav1.Duration `json:"lastProbeLatency,omitempty" protobuf:"bytes,9,opt,name=lastProbeLatency"`
+	// IP is the address the host resolved to, when the host is a DNS name.
+	// +optional
+	IP string `json:"ip,omitempty" protobuf:"bytes,10,opt,name=ip"`
+	// Certificate is the leaf certificate presented by the host, when the port checks it.
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty" protobuf:"bytes,11,opt,name=certificate"`
+	// Checks are the results of the last run of each check of the port.
+	// +optional
+	Checks []CheckStatus `json:"checks,omitempty" protobuf:"bytes,12,rep,name=checks"`
+	// Backup is true when the host is one of the backupHosts of the port.
+	// +optional
+	Backup bool `json:"backup,omitempty" protobuf:"varint,13,opt,name=backup"`
+	// Flapping is true while the host is held out because its health changed too often.
+	// +optional
+	Flapping bool `json:"flapping,omitempty" protobuf:"varint,14,opt,name=flapping"`
+	// Draining is true when the host is drained, it is not published whatever its health.
+	// +optional
+	Draining bool `json:"draining,omitempty" protobuf:"varint,15,opt,name=draining"`
+}
+
+// CheckStatus is the result of the last run of a check against a host.
+type CheckStatus struct {
+	// Name is the name of the check.
+	Name string `json:"name" protobuf:"bytes,1,opt,name=name"`
+	// Ready is true when the last run of the check succeeded.
+	Ready bool `json:"ready" protobuf:"varint,2,opt,name=ready"`
+	// LastError is the output of the last run when it failed.
+	// +optional
+	LastError string `json:"lastError,omitempty" protobuf:"bytes,3,opt,name=lastError"`
+	// LastProbeLatency is how long the last run took.
+	// +optional
+	LastProbeLatency metav1.Duration `json:"lastProbeLatency,omitempty" protobuf:"bytes,4,opt,name=lastProbeLatency"`
+}
+
+// CertificateStatus describes the leaf certificate presented by a host.
+type CertificateStatus struct {
+	// NotAfter is when the certificate expires.
+	// +optional
+	NotAfter metav1.Time `json:"notAfter,omitempty" protobuf:"bytes,1,opt,name=notAfter"`
+	// Issuer is the distinguished name of the issuer of the certificate.
+	// +optional
+	Issuer string `json:"issuer,omitempty" protobuf:"bytes,2,opt,name=issuer"`
+	// SubjectAltNames are the DNS names and IP addresses the certificate is valid for.
+	// +optional
+	SubjectAltNames []string `json:"subjectAltNames,omitempty" protobuf:"bytes,3,rep,name=subjectAltNames"`
+	// Expiring is true when the certificate is valid for less than minValidDays.
+	// +optional
+	Expiring bool `json:"expiring,omitempty" protobuf:"varint,4,opt,name=expiring"`
+	// LastError is why the certificate could not be read.
+	// +optional
+	LastError string `json:"lastError,omitempty" protobuf:"bytes,5,opt,name=lastError"`
+}
+
 // ClusterEndpointStatus defines the observed state of ClusterEndpoint
 type ClusterEndpointStatus struct {
 	// Phase  is the recently observed lifecycle phase of the cluster endpoints.
 	Phase Phase `json:"phase,omitempty" protobuf:"bytes,1,opt,name=phase,casttype=Phase"`
 	// Conditions contains the different condition statuses for this workspace.
 	Conditions []Condition `json:"conditions" protobuf:"bytes,3,rep,name=conditions"`
+	// Hosts contains the health of every host of every port.
+	// +optional
+	Hosts []HostStatus `json:"hosts,omitempty" protobuf:"bytes,4,rep,name=hosts"`
 }
 
 // +kubebuilder:object:root=true
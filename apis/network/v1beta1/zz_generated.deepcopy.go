@@ -26,6 +26,74 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateCheck) DeepCopyInto(out *CertificateCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateCheck.
+func (in *CertificateCheck) DeepCopy() *CertificateCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateStatus) DeepCopyInto(out *CertificateStatus) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+	if in.SubjectAltNames != nil {
+		in, out := &in.SubjectAltNames, &out.SubjectAltNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateStatus.
+func (in *CertificateStatus) DeepCopy() *CertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Check) DeepCopyInto(out *Check) {
+	*out = *in
+	in.Handler.DeepCopyInto(&out.Handler)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Check.
+func (in *Check) DeepCopy() *Check {
+	if in == nil {
+		return nil
+	}
+	out := new(Check)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CheckStatus) DeepCopyInto(out *CheckStatus) {
+	*out = *in
+	out.LastProbeLatency = in.LastProbeLatency
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckStatus.
+func (in *CheckStatus) DeepCopy() *CheckStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CheckStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterEndpoint) DeepCopyInto(out *ClusterEndpoint) {
 	*out = *in
@@ -95,6 +163,41 @@ func (in *ClusterEndpointSpec) DeepCopyInto(out *ClusterEndpointSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.DrainedHosts != nil {
+		in, out := &in.DrainedHosts, &out.DrainedHosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterIPs != nil {
+		in, out := &in.ClusterIPs, &out.ClusterIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SessionAffinityConfig != nil {
+		in, out := &in.SessionAffinityConfig, &out.SessionAffinityConfig
+		*out = new(v1.SessionAffinityConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPFamilies != nil {
+		in, out := &in.IPFamilies, &out.IPFamilies
+		*out = make([]v1.IPFamily, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPFamilyPolicy != nil {
+		in, out := &in.IPFamilyPolicy, &out.IPFamilyPolicy
+		*out = new(v1.IPFamilyPolicy)
+		**out = **in
+	}
+	if in.InternalTrafficPolicy != nil {
+		in, out := &in.InternalTrafficPolicy, &out.InternalTrafficPolicy
+		*out = new(v1.ServiceInternalTrafficPolicy)
+		**out = **in
+	}
+	if in.LoadBalancerSourceRanges != nil {
+		in, out := &in.LoadBalancerSourceRanges, &out.LoadBalancerSourceRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEndpointSpec.
@@ -117,6 +220,13 @@ func (in *ClusterEndpointStatus) DeepCopyInto(out *ClusterEndpointStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]HostStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterEndpointStatus.
@@ -146,6 +256,56 @@ func (in *Condition) DeepCopy() *Condition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSQuery) DeepCopyInto(out *DNSQuery) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSQuery.
+func (in *DNSQuery) DeepCopy() *DNSQuery {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSQuery)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecAction) DeepCopyInto(out *ExecAction) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecAction.
+func (in *ExecAction) DeepCopy() *ExecAction {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlapDetection) DeepCopyInto(out *FlapDetection) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlapDetection.
+func (in *FlapDetection) DeepCopy() *FlapDetection {
+	if in == nil {
+		return nil
+	}
+	out := new(FlapDetection)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GRPCAction) DeepCopyInto(out *GRPCAction) {
 	*out = *in
@@ -154,6 +314,11 @@ func (in *GRPCAction) DeepCopyInto(out *GRPCAction) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GRPCAction.
@@ -174,6 +339,21 @@ func (in *HTTPGetAction) DeepCopyInto(out *HTTPGetAction) {
 		*out = make([]v1.HTTPHeader, len(*in))
 		copy(*out, *in)
 	}
+	if in.ExpectedStatuses != nil {
+		in, out := &in.ExpectedStatuses, &out.ExpectedStatuses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResponseBody != nil {
+		in, out := &in.ResponseBody, &out.ResponseBody
+		*out = new(HTTPResponseBody)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPGetAction.
@@ -186,9 +366,29 @@ func (in *HTTPGetAction) DeepCopy() *HTTPGetAction {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPResponseBody) DeepCopyInto(out *HTTPResponseBody) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPResponseBody.
+func (in *HTTPResponseBody) DeepCopy() *HTTPResponseBody {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPResponseBody)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Handler) DeepCopyInto(out *Handler) {
 	*out = *in
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(ExecAction)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.HTTPGet != nil {
 		in, out := &in.HTTPGet, &out.HTTPGet
 		*out = new(HTTPGetAction)
@@ -209,6 +409,21 @@ func (in *Handler) DeepCopyInto(out *Handler) {
 		*out = new(GRPCAction)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.MySQL != nil {
+		in, out := &in.MySQL, &out.MySQL
+		*out = new(MySQLAction)
+		**out = **in
+	}
+	if in.PostgreSQL != nil {
+		in, out := &in.PostgreSQL, &out.PostgreSQL
+		*out = new(PostgreSQLAction)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Redis != nil {
+		in, out := &in.Redis, &out.Redis
+		*out = new(RedisAction)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Handler.
@@ -221,6 +436,89 @@ func (in *Handler) DeepCopy() *Handler {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostStatus) DeepCopyInto(out *HostStatus) {
+	*out = *in
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	out.LastProbeLatency = in.LastProbeLatency
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]CheckStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostStatus.
+func (in *HostStatus) DeepCopy() *HostStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HostStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MySQLAction) DeepCopyInto(out *MySQLAction) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MySQLAction.
+func (in *MySQLAction) DeepCopy() *MySQLAction {
+	if in == nil {
+		return nil
+	}
+	out := new(MySQLAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PostgreSQLAction) DeepCopyInto(out *PostgreSQLAction) {
+	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PostgreSQLAction.
+func (in *PostgreSQLAction) DeepCopy() *PostgreSQLAction {
+	if in == nil {
+		return nil
+	}
+	out := new(PostgreSQLAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisAction) DeepCopyInto(out *RedisAction) {
+	*out = *in
+	if in.PasswordSecret != nil {
+		in, out := &in.PasswordSecret, &out.PasswordSecret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisAction.
+func (in *RedisAction) DeepCopy() *RedisAction {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServicePort) DeepCopyInto(out *ServicePort) {
 	*out = *in
@@ -229,7 +527,29 @@ func (in *ServicePort) DeepCopyInto(out *ServicePort) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.BackupHosts != nil {
+		in, out := &in.BackupHosts, &out.BackupHosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.Handler.DeepCopyInto(&out.Handler)
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CertificateCheck)
+		**out = **in
+	}
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]Check, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.FlapDetection != nil {
+		in, out := &in.FlapDetection, &out.FlapDetection
+		*out = new(FlapDetection)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServicePort.
@@ -257,6 +577,61 @@ func (in *TCPSocketAction) DeepCopy() *TCPSocketAction {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSConfig) DeepCopyInto(out *TLSConfig) {
+	*out = *in
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(TLSSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cert != nil {
+		in, out := &in.Cert, &out.Cert
+		*out = new(TLSSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KeySecret != nil {
+		in, out := &in.KeySecret, &out.KeySecret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSConfig.
+func (in *TLSConfig) DeepCopy() *TLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSSelector) DeepCopyInto(out *TLSSelector) {
+	*out = *in
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(v1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSSelector.
+func (in *TLSSelector) DeepCopy() *TLSSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UDPSocketAction) DeepCopyInto(out *UDPSocketAction) {
 	*out = *in
@@ -265,6 +640,16 @@ func (in *UDPSocketAction) DeepCopyInto(out *UDPSocketAction) {
 		*out = make([]uint8, len(*in))
 		copy(*out, *in)
 	}
+	if in.ExpectPrefix != nil {
+		in, out := &in.ExpectPrefix, &out.ExpectPrefix
+		*out = make([]uint8, len(*in))
+		copy(*out, *in)
+	}
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(DNSQuery)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UDPSocketAction.
@@ -23,6 +23,7 @@ import (
 	client2 "github.com/labring/endpoints-operator/utils/client"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	"github.com/labring/endpoints-operator/cmd/cepctl/app/options"
@@ -32,6 +33,7 @@ import (
 	v1opts "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/util/retry"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/term"
 	"k8s.io/klog/v2"
@@ -86,6 +88,9 @@ func run(s *options.Options, ctx context.Context) error {
 	if cli == nil {
 		return errors.New("build kube client error")
 	}
+	if s.Drain != "" || s.Undrain != "" {
+		return toggleDrain(ctx, client2.NewCep(cli.KubernetesDynamic()), s)
+	}
 	cep := &v1beta1.ClusterEndpoint{}
 	cep.Namespace = s.Namespace
 	cep.Name = s.Name
@@ -99,6 +104,15 @@ func run(s *options.Options, ctx context.Context) error {
 		return errors.New("not support clusterIP=None service")
 	}
 	cep.Spec.ClusterIP = svc.Spec.ClusterIP
+	cep.Spec.ClusterIPs = svc.Spec.ClusterIPs
+	cep.Spec.Type = svc.Spec.Type
+	cep.Spec.SessionAffinity = svc.Spec.SessionAffinity
+	cep.Spec.SessionAffinityConfig = svc.Spec.SessionAffinityConfig
+	cep.Spec.IPFamilies = svc.Spec.IPFamilies
+	cep.Spec.IPFamilyPolicy = svc.Spec.IPFamilyPolicy
+	cep.Spec.InternalTrafficPolicy = svc.Spec.InternalTrafficPolicy
+	cep.Spec.ExternalTrafficPolicy = svc.Spec.ExternalTrafficPolicy
+	cep.Spec.LoadBalancerSourceRanges = svc.Spec.LoadBalancerSourceRanges
 	ep, _ := cli.Kubernetes().CoreV1().Endpoints(s.Namespace).Get(ctx, s.Name, v1opts.GetOptions{})
 	if ep != nil {
 		klog.V(4).InfoS("get endpoint", "name", s.Name, "namespace", s.Namespace, "subsets", ep.Subsets)
@@ -124,7 +138,8 @@ func run(s *options.Options, ctx context.Context) error {
 					FailureThreshold: 3,
 					Name:             port.Name,
 					Protocol:         port.Protocol,
-					Port:             findPortInSvc(svc, port.Name),
+					Port:             findPortInSvc(svc, port.Name).Port,
+					NodePort:         findPortInSvc(svc, port.Name).NodePort,
 					TargetPort:       port.Port,
 					Hosts:            ips,
 				})
@@ -150,14 +165,47 @@ func run(s *options.Options, ctx context.Context) error {
 	return c.CreateCR(ctx, cep)
 }
 
-func findPortInSvc(svc *v1.Service, portName string) int32 {
+// toggleDrain adds the host to the drain annotation of the cep, or removes it.
+// The annotation is written at the resourceVersion it was read at, and read again on conflict,
+// so that concurrent drains do not drop each other's hosts.
+func toggleDrain(ctx context.Context, c *client2.Cep, s *options.Options) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		annotations, resourceVersion, err := c.GetAnnotations(ctx, s.Namespace, s.Name)
+		if err != nil {
+			return err
+		}
+		hosts := drainHosts(annotations[v1beta1.DrainAnnotation], s.Drain, s.Undrain)
+		klog.V(4).InfoS("drain hosts", "name", s.Name, "namespace", s.Namespace, "hosts", hosts)
+		if len(hosts) == 0 {
+			return c.PatchAnnotation(ctx, s.Namespace, s.Name, resourceVersion, v1beta1.DrainAnnotation, nil)
+		}
+		value := strings.Join(hosts, ",")
+		return c.PatchAnnotation(ctx, s.Namespace, s.Name, resourceVersion, v1beta1.DrainAnnotation, &value)
+	})
+}
+
+// drainHosts returns the hosts of the drain annotation with the drained host added and the undrained one removed.
+func drainHosts(annotation, drain, undrain string) []string {
+	var hosts []string
+	for _, host := range strings.Split(annotation, ",") {
+		if host = strings.TrimSpace(host); host != "" && host != drain && host != undrain {
+			hosts = append(hosts, host)
+		}
+	}
+	if drain != "" {
+		hosts = append(hosts, drain)
+	}
+	return hosts
+}
+
+func findPortInSvc(svc *v1.Service, portName string) v1.ServicePort {
 
 	if svc != nil {
 		for _, p := range svc.Spec.Ports {
 			if p.Name == portName {
-				return p.Port
+				return p
 			}
 		}
 	}
-	return 0
+	return v1.ServicePort{}
 }
@@ -36,6 +36,8 @@ type Options struct {
 	PeriodSeconds int32
 	Probe         bool
 	Output        string
+	Drain         string
+	Undrain       string
 	Version       bool
 	Short         bool
 }
@@ -66,6 +68,8 @@ func (s *Options) Flags() cliflag.NamedFlagSets {
 	cep.StringVar(&s.Name, "service-name", s.Name, "Sync cap from service name.")
 	cep.StringVar(&s.Namespace, "service-namespace", s.Namespace, "Sync cap from service namespace.")
 	cep.StringVarP(&s.Output, "output", "o", s.Output, "output json|yaml. if not set,will create cep to kubernetes")
+	cep.StringVar(&s.Drain, "drain", s.Drain, "Drain the host of the cep named service-name instead of creating it, by adding it to the sealos.io/drain annotation.")
+	cep.StringVar(&s.Undrain, "undrain", s.Undrain, "Undrain the host of the cep named service-name instead of creating it, by removing it from the sealos.io/drain annotation.")
 
 	probe := fss.FlagSet("probe")
 	probe.Int32Var(&s.PeriodSeconds, "periodSeconds", s.PeriodSeconds, "How often (in seconds) to perform the probe.Default is 10.")
@@ -103,6 +107,9 @@ func (s *Options) Validate() []error {
 	if len(s.Namespace) == 0 {
 		errs = append(errs, errors.New("service namespace must not empty"))
 	}
+	if len(s.Drain) != 0 && len(s.Undrain) != 0 {
+		errs = append(errs, errors.New("only one of drain and undrain may be set"))
+	}
 	if len(s.Output) != 0 {
 		if s.Output != "yaml" && s.Output != "json" {
 			errs = append(errs, errors.New("output must be is yaml or json"))
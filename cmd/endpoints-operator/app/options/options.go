@@ -34,6 +34,7 @@ type Options struct {
 	LeaderElectionResourceLock string
 	MaxConcurrent              int
 	MaxRetry                   int
+	EnableExecProbe            bool
 	RateLimiterOptions         utilcontroller.RateLimiterOptions
 }
 
@@ -74,6 +75,10 @@ func (s *Options) Flags() cliflag.NamedFlagSets {
 		"which can be run. Defaults to 1.")
 	mc.IntVar(&s.MaxRetry, "maxretry", 1, "MaxRetry this is the maximum number of retry liveliness "+
 		"which can be run. Defaults to 1.")
+	pfs := fss.FlagSet("probe")
+	pfs.BoolVar(&s.EnableExecProbe, "enable-exec-probe", false, "EnableExecProbe allows the ClusterEndpoints to run "+
+		"exec probes. The commands run inside the operator container with the permissions of its ServiceAccount, "+
+		"so that anyone who can create a ClusterEndpoint can run them. Defaults to false.")
 	s.RateLimiterOptions.BindFlags(flag.CommandLine)
 	return fss
 }
@@ -103,6 +103,7 @@ func run(s *options.Options, ctx context.Context) error {
 	metricsInfo.RegisterAllMetrics()
 
 	mgrOptions.Scheme = scheme
+	mgrOptions.Cache = controllers.CacheOptions()
 	mgrOptions.HealthProbeBindAddress = ":8080"
 	mgrOptions.MetricsBindAddress = ":9090"
 	klog.V(0).Info("setting up manager")
@@ -118,6 +119,7 @@ func run(s *options.Options, ctx context.Context) error {
 	}
 	klog.V(4).Info("[****] MaxConcurrent value is ", s.MaxConcurrent)
 	klog.V(4).Info("[****] MaxRetry value is ", s.MaxRetry)
+	klog.V(4).Info("[****] EnableExecProbe value is ", s.EnableExecProbe)
 
 	controllers.Install(scheme)
 	clusterReconciler := &controllers.Reconciler{}
@@ -132,6 +134,8 @@ func run(s *options.Options, ctx context.Context) error {
 		clusterReconciler.RetryCount = 1
 	}
 
+	clusterReconciler.EnableExecProbe = s.EnableExecProbe
+
 	clusterReconciler.RateLimiter = controller.GetRateLimiter(s.RateLimiterOptions)
 
 	clusterReconciler.MetricsInfo = metricsInfo
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+)
+
+// certificateInfo is the leaf certificate presented by an address.
+type certificateInfo struct {
+	notAfter time.Time
+	issuer   string
+	sans     []string
+	err      error
+}
+
+func certificateCheckEnabled(port v1beta1.ServicePort) bool {
+	return port.Certificate != nil && port.Certificate.Enable
+}
+
+// certificateTLSConfig returns the config of the handshake that reads the certificate of the host.
+// The certificate is always read, even when it would not be trusted.
+func certificateTLSConfig(probeConfig *tls.Config, host string) *tls.Config {
+	config := &tls.Config{}
+	if probeConfig != nil {
+		config = probeConfig.Clone()
+	} else if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	config.InsecureSkipVerify = true // nolint: gosec
+	return config
+}
+
+// readCertificate opens a TLS connection to the address and returns the leaf certificate of the server.
+func readCertificate(host string, port int32, config *tls.Config, timeout time.Duration) certificateInfo {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))), config)
+	if err != nil {
+		return certificateInfo{err: err}
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return certificateInfo{err: errors.New("no certificate presented")}
+	}
+	return newCertificateInfo(certs[0])
+}
+
+func newCertificateInfo(cert *x509.Certificate) certificateInfo {
+	info := certificateInfo{notAfter: cert.NotAfter, issuer: cert.Issuer.String()}
+	info.sans = append(info.sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		info.sans = append(info.sans, ip.String())
+	}
+	return info
+}
+
+// certificateExpiring reports whether the certificate is valid for less than the minValidDays of the check.
+func certificateExpiring(check *v1beta1.CertificateCheck, info *certificateInfo, now time.Time) bool {
+	if check == nil || check.MinValidDays <= 0 || info == nil || info.err != nil {
+		return false
+	}
+	return info.notAfter.Before(now.Add(time.Duration(check.MinValidDays) * 24 * time.Hour))
+}
+
+func certificateExpiringMessage(info *certificateInfo, now time.Time) string {
+	return fmt.Sprintf("certificate issued by %s expires in %d days, at %s", info.issuer, int(info.notAfter.Sub(now).Hours()/24), info.notAfter.UTC().Format(time.RFC3339))
+}
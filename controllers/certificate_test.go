@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/endpoints-operator/utils/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func TestReadCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	info := readCertificate("127.0.0.1", int32(addr.Port), certificateTLSConfig(nil, "127.0.0.1"), time.Second)
+	if info.err != nil {
+		t.Fatal(info.err)
+	}
+	if !info.notAfter.Equal(srv.Certificate().NotAfter) {
+		t.Errorf("notAfter = %v, want %v", info.notAfter, srv.Certificate().NotAfter)
+	}
+	if len(info.sans) == 0 {
+		t.Error("expected subject alternative names")
+	}
+
+	check := &v1beta1.CertificateCheck{Enable: true, MinValidDays: 30}
+	if certificateExpiring(check, &info, info.notAfter.Add(-31*24*time.Hour)) {
+		t.Error("expected certificate valid for 31 days not to be expiring")
+	}
+	if !certificateExpiring(check, &info, info.notAfter.Add(-29*24*time.Hour)) {
+		t.Error("expected certificate valid for 29 days to be expiring")
+	}
+}
+
+func TestCertificateMetricRemoved(t *testing.T) {
+	metricsInfo := metrics.NewMetricsInfo()
+	metricsInfo.RegisterAllMetrics()
+	m := newProberManager(1, metricsInfo)
+	port := v1beta1.ServicePort{Name: "default", TargetPort: 443}
+	key := newProbeKey(types.NamespacedName{Namespace: "default", Name: "cep"}, port, "db.example.com").withAddress("10.0.0.1")
+	count := func() int {
+		n, err := testutil.GatherAndCount(crmetrics.Registry, "cep_certificate_not_after_seconds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	m.recordCertificate(key, certificateInfo{notAfter: time.Now(), issuer: "CN=old"})
+	m.recordCertificate(key, certificateInfo{notAfter: time.Now(), issuer: "CN=ca"})
+	m.setResult(key, probeResult{initialized: true, ready: true})
+	if n := count(); n != 1 {
+		t.Fatalf("expected one certificate series, got %d", n)
+	}
+	m.removeResult(key.withAddress(key.host))
+	if n := count(); n != 0 {
+		t.Errorf("expected the certificate series to be removed with the host, got %d", n)
+	}
+}
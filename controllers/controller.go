@@ -24,11 +24,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
@@ -41,6 +44,10 @@ import (
 
 const (
 	controllerName = "cluster_endpoints_controller"
+	// fieldManager owns the fields of the Service, the Endpoints and the EndpointSlices written with server-side apply.
+	fieldManager = "endpoints-operator"
+	// statusRefreshInterval is how often the status is written when only the probe details of the hosts changed.
+	statusRefreshInterval = time.Minute
 )
 
 // Reconciler reconciles a Service object
@@ -50,20 +57,30 @@ type Reconciler struct {
 	recorder      record.EventRecorder
 	scheme        *runtime.Scheme
 	finalizer     *controller.Finalizer
+	prober        *proberManager
 	RetryCount    int
 	MaxConcurrent int
 	MetricsInfo   *metrics.MetricsInfo
 	RateLimiter   ratelimiter.RateLimiter
+
+	// EnableExecProbe allows the ClusterEndpoints to run exec probes inside the operator container.
+	EnableExecProbe bool
 }
 
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	r.logger.V(4).Info("start reconcile for ceps")
 	cep := &v1beta1.ClusterEndpoint{}
 	if err := r.Get(ctx, req.NamespacedName, cep); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.prober.RemoveClusterEndpoint(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if ok, err := r.finalizer.RemoveFinalizer(ctx, cep, controller.DefaultFunc); ok {
+	if ok, err := r.finalizer.RemoveFinalizer(ctx, cep, r.finalize); ok {
+		if err != nil {
+			r.recorder.Eventf(cep, corev1.EventTypeWarning, "CleanupFailed", "Cleanup of %s is error: %v", cep.Name, err)
+		}
 		return ctrl.Result{}, err
 	}
 
@@ -77,6 +94,22 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	return ctrl.Result{}, errors.New("reconcile error from Finalizer")
 }
 
+// finalize stops the probes once the owned resources are released. On failure the workers keep running,
+// so that an orphaned Service does not lose its ready hosts while the finalizer is retried.
+func (r *Reconciler) finalize(ctx context.Context, obj client.Object) error {
+	var err error
+	if cep, ok := obj.(*v1beta1.ClusterEndpoint); ok && cep.Spec.DeletionPolicy == v1beta1.DeletionPolicyOrphan {
+		err = r.orphanResources(ctx, cep)
+	} else {
+		err = controller.DefaultFunc(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+	r.prober.RemoveClusterEndpoint(client.ObjectKeyFromObject(obj))
+	return nil
+}
+
 func (c *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if c.Client == nil {
 		c.Client = mgr.GetClient()
@@ -88,14 +121,22 @@ func (c *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if c.finalizer == nil {
 		c.finalizer = controller.NewFinalizer(c.Client, "sealos.io/cluster-endpoints.finalizers")
 	}
+	if c.prober == nil {
+		c.prober = newProberManager(c.RetryCount, c.MetricsInfo)
+		c.prober.execEnabled = c.EnableExecProbe
+	}
 	c.scheme = mgr.GetScheme()
 	c.logger.V(4).Info("init reconcile controller service")
 	owner := handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), &v1beta1.ClusterEndpoint{}, handler.OnlyControllerOwner())
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta1.ClusterEndpoint{}, builder.WithPredicates(
-			predicate.Or(predicate.GenerationChangedPredicate{}))).
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Watches(&corev1.Service{}, owner).
+		Watches(&discoveryv1.EndpointSlice{}, owner).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(c.materialRequests)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(c.materialRequests)).
+		WatchesRawSource(&source.Channel{Source: c.prober.Updates()}, &handler.EnqueueRequestForObject{}).
 		WithOptions(runtimecontroller.Options{
 			MaxConcurrentReconciles: c.MaxConcurrent,
 			RateLimiter:             c.RateLimiter,
@@ -118,24 +159,36 @@ func (c *Reconciler) reconcile(ctx context.Context, obj client.Object) (ctrl.Res
 		LastHeartbeatTime:  metav1.Now(),
 		LastTransitionTime: metav1.Now(),
 	}
+	current := cep.Status.DeepCopy()
 	cep.Status.Phase = v1beta1.Pending
 	if !isConditionTrue(cep, v1beta1.Initialized) {
 		c.updateCondition(cep, initializedCondition)
 	}
 
-	c.syncService(ctx, cep)
-	c.syncEndpoint(ctx, cep)
+	c.prober.UpdateClusterEndpoint(cep, c.loadPortMaterials(ctx, cep))
+	paused := c.syncPaused(cep)
+	if !paused {
+		c.syncService(ctx, cep)
+	}
+	subsets, syncError := c.convertEndpointSubset(ctx, cep, paused)
+	c.syncHostsResolved(cep)
+	if !paused {
+		c.syncEndpoint(ctx, cep, subsets, syncError)
+		c.syncEndpointSlice(ctx, cep, subsets)
+	}
 
 	c.logger.V(4).Info("update finished reconcile controller service", "request", client.ObjectKeyFromObject(cep))
 	c.syncFinalStatus(cep)
-	err := c.updateStatus(ctx, client.ObjectKeyFromObject(cep), &cep.Status)
-	if err != nil {
-		c.recorder.Eventf(cep, corev1.EventTypeWarning, "SyncStatus", "Sync status %s is error: %v", cep.Name, err)
-		return ctrl.Result{}, err
+	if now := time.Now(); statusNeedsUpdate(current, &cep.Status, now) {
+		refreshHeartbeats(&cep.Status, metav1.NewTime(now))
+		if err := c.updateStatus(ctx, client.ObjectKeyFromObject(cep), &cep.Status); err != nil {
+			c.recorder.Eventf(cep, corev1.EventTypeWarning, "SyncStatus", "Sync status %s is error: %v", cep.Name, err)
+			return ctrl.Result{}, err
+		}
 	}
-	sec := time.Duration(cep.Spec.PeriodSeconds) * time.Second
+	// Requeue to refresh the probe details of the hosts, health flips are enqueued by the workers.
 	if cep.Spec.PeriodSeconds == 0 {
 		return ctrl.Result{}, nil
 	}
-	return ctrl.Result{RequeueAfter: sec}, nil
+	return ctrl.Result{RequeueAfter: time.Duration(cep.Spec.PeriodSeconds) * time.Second}, nil
 }
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestFinalizeOrphan(t *testing.T) {
+	cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cep", UID: "cep-uid"},
+		Spec: v1beta1.ClusterEndpointSpec{
+			DeletionPolicy: v1beta1.DeletionPolicyOrphan,
+			Ports: []v1beta1.ServicePort{{
+				Name:       "default",
+				Hosts:      []string{"127.0.0.1"},
+				Handler:    v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+				TargetPort: 1,
+			}},
+		},
+	}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cep", OwnerReferences: []metav1.OwnerReference{{
+		APIVersion: v1beta1.GroupVersion.String(), Kind: "ClusterEndpoint", Name: "cep", UID: cep.UID,
+	}}}}
+	patchErr := errors.New("patch failed")
+	c := fake.NewClientBuilder().WithObjects(svc).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patchErr != nil {
+				return patchErr
+			}
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	}).Build()
+	m := newProberManager(1, nil)
+	r := &Reconciler{Client: c, prober: m}
+	m.UpdateClusterEndpoint(cep, nil)
+	nn := client.ObjectKeyFromObject(cep)
+
+	if err := r.finalize(context.Background(), cep); err == nil {
+		t.Fatal("finalize() error = nil, want the patch error")
+	}
+	if len(m.workers[nn]) == 0 {
+		t.Error("workers stopped although the owner references were not removed")
+	}
+
+	patchErr = nil
+	if err := r.finalize(context.Background(), cep); err != nil {
+		t.Fatalf("finalize() error = %v", err)
+	}
+	if len(m.workers[nn]) != 0 {
+		t.Error("workers still running after finalize()")
+	}
+	got := &corev1.Service{}
+	if err := c.Get(context.Background(), nn, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.OwnerReferences) != 0 {
+		t.Errorf("owner references of the Service = %v, want none", got.OwnerReferences)
+	}
+}
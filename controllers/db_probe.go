@@ -0,0 +1,244 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labring/operator-sdk/probe"
+)
+
+const (
+	// mysqlProtocolV10 is the first byte of the initial handshake packet.
+	mysqlProtocolV10 = 0x0a
+	mysqlErrPacket   = 0xff
+	// mysqlMaxHandshakeLength bounds the packet the probe reads, a handshake is well under 1KB
+	// while the header of another service could announce up to 16MB.
+	mysqlMaxHandshakeLength = 64 << 10
+
+	// postgresSSLRequestCode and postgresProtocolV3 are sent in the first message of a connection.
+	postgresSSLRequestCode = 80877103
+	postgresProtocolV3     = 196608
+	// postgresProbeUser is the user of the startup message, the probe never authenticates.
+	postgresProbeUser = "endpoints-operator"
+)
+
+// dialProbe opens a TCP connection to the host with the timeout as deadline for the whole exchange.
+// Connection errors are returned as failure output, like the TCP probe does.
+func dialProbe(host string, port int, timeout time.Duration) (net.Conn, string) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, err.Error()
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	return conn, ""
+}
+
+// mysqlProbe checks that the server greets with a handshake packet.
+// The server sends an error packet instead when it refuses the client, for example
+// because of too many connections or a blocked host.
+func mysqlProbe(host string, port int, timeout time.Duration) (probe.Result, string, error) {
+	conn, output := dialProbe(host, port, timeout)
+	if conn == nil {
+		return probe.Failure, output, nil
+	}
+	defer conn.Close()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return probe.Failure, fmt.Sprintf("failed to read mysql handshake: %v", err), nil
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length > mysqlMaxHandshakeLength {
+		return probe.Failure, fmt.Sprintf("mysql handshake of %d bytes is longer than %d bytes", length, mysqlMaxHandshakeLength), nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil || length == 0 {
+		return probe.Failure, fmt.Sprintf("failed to read mysql handshake: %v", err), nil
+	}
+	switch payload[0] {
+	case mysqlProtocolV10:
+		version, _, _ := bytes.Cut(payload[1:], []byte{0})
+		return probe.Success, fmt.Sprintf("mysql %s", version), nil
+	case mysqlErrPacket:
+		// 0xff, error code, and the message, optionally prefixed by '#' and the sql state.
+		if len(payload) < 3 {
+			return probe.Failure, "mysql error", nil
+		}
+		code := binary.LittleEndian.Uint16(payload[1:3])
+		msg := payload[3:]
+		if len(msg) > 6 && msg[0] == '#' {
+			msg = msg[6:]
+		}
+		return probe.Failure, fmt.Sprintf("mysql error %d: %s", code, msg), nil
+	}
+	return probe.Failure, fmt.Sprintf("unexpected mysql protocol version %d", payload[0]), nil
+}
+
+// postgresProbe checks that the server answers a startup message with an authentication request, like pg_isready.
+// Errors are only failures when they mean the server does not accept connections, the probe user
+// being unknown is fine. With a TLS config the server has to accept TLS and is verified with it,
+// without one the connection is upgraded without verification when the server offers TLS.
+func postgresProbe(host string, port int, tlsConfig *tls.Config, timeout time.Duration) (probe.Result, string, error) {
+	conn, output := dialProbe(host, port, timeout)
+	if conn == nil {
+		return probe.Failure, output, nil
+	}
+	defer conn.Close()
+
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(sslRequest); err != nil {
+		return probe.Failure, fmt.Sprintf("failed to send postgresql ssl request: %v", err), nil
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return probe.Failure, fmt.Sprintf("failed to read postgresql ssl response: %v", err), nil
+	}
+	switch answer[0] {
+	case 'S':
+		config := tlsConfig
+		if config == nil {
+			config = certificateTLSConfig(nil, host)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return probe.Failure, fmt.Sprintf("postgresql tls handshake failed: %v", err), nil
+		}
+		conn = tlsConn
+	case 'N':
+		if tlsConfig != nil {
+			return probe.Failure, "postgresql server does not accept tls", nil
+		}
+	default:
+		return probe.Failure, fmt.Sprintf("unexpected postgresql ssl response %q", answer[0]), nil
+	}
+
+	var params bytes.Buffer
+	_ = binary.Write(&params, binary.BigEndian, uint32(postgresProtocolV3))
+	for _, s := range []string{"user", postgresProbeUser, "database", "postgres", ""} {
+		params.WriteString(s)
+		params.WriteByte(0)
+	}
+	startup := make([]byte, 4, 4+params.Len())
+	binary.BigEndian.PutUint32(startup, uint32(4+params.Len()))
+	startup = append(startup, params.Bytes()...)
+	if _, err := conn.Write(startup); err != nil {
+		return probe.Failure, fmt.Sprintf("failed to send postgresql startup message: %v", err), nil
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return probe.Failure, fmt.Sprintf("failed to read postgresql startup response: %v", err), nil
+	}
+	switch header[0] {
+	case 'R':
+		return probe.Success, "postgresql accepting connections", nil
+	case 'E':
+		length := int(binary.BigEndian.Uint32(header[1:5])) - 4
+		if length < 0 || length > 1<<16 {
+			return probe.Failure, "invalid postgresql error response", nil
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return probe.Failure, fmt.Sprintf("failed to read postgresql error response: %v", err), nil
+		}
+		code, msg := postgresErrorFields(body)
+		// Class 57 is operator intervention (starting up, shutting down), class 53 is insufficient resources.
+		if strings.HasPrefix(code, "57") || strings.HasPrefix(code, "53") {
+			return probe.Failure, fmt.Sprintf("postgresql error %s: %s", code, msg), nil
+		}
+		return probe.Success, fmt.Sprintf("postgresql accepting connections (%s: %s)", code, msg), nil
+	}
+	return probe.Failure, fmt.Sprintf("unexpected postgresql startup response %q", header[0]), nil
+}
+
+// postgresErrorFields returns the sql state and the message of an ErrorResponse body.
+func postgresErrorFields(body []byte) (code, msg string) {
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+		switch field[0] {
+		case 'C':
+			code = string(field[1:])
+		case 'M':
+			msg = string(field[1:])
+		}
+	}
+	return code, msg
+}
+
+// redisProbe checks that the server answers PING with PONG, after AUTH when a password is given.
+// A server that is still loading its dataset answers with a LOADING error.
+func redisProbe(host string, port int, username string, password []byte, timeout time.Duration) (probe.Result, string, error) {
+	conn, output := dialProbe(host, port, timeout)
+	if conn == nil {
+		return probe.Failure, output, nil
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if len(password) != 0 {
+		args := []string{"AUTH", string(password)}
+		if username != "" {
+			args = []string{"AUTH", username, string(password)}
+		}
+		reply, err := redisCommand(conn, reader, args...)
+		if err != nil {
+			return probe.Failure, fmt.Sprintf("redis AUTH failed: %v", err), nil
+		}
+		if reply != "+OK" {
+			return probe.Failure, fmt.Sprintf("redis AUTH failed: %s", strings.TrimPrefix(reply, "-")), nil
+		}
+	}
+	reply, err := redisCommand(conn, reader, "PING")
+	if err != nil {
+		return probe.Failure, fmt.Sprintf("redis PING failed: %v", err), nil
+	}
+	if reply != "+PONG" {
+		return probe.Failure, fmt.Sprintf("redis PING failed: %s", strings.TrimPrefix(reply, "-")), nil
+	}
+	return probe.Success, "PONG", nil
+}
+
+// redisCommand sends the command as a RESP array and returns the first line of the reply.
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
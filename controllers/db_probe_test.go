@@ -0,0 +1,165 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labring/operator-sdk/probe"
+)
+
+// serveOnce accepts a single connection on a local listener and hands it to the handler.
+func serveOnce(t *testing.T, handle func(conn net.Conn)) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func mysqlPacket(payload string) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), 0}, payload...)
+}
+
+func postgresMessage(typ byte, body string) []byte {
+	msg := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+len(body)))
+	return append(msg, body...)
+}
+
+func TestDatabaseProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(conn net.Conn)
+		run    func(port int) (probe.Result, string, error)
+		want   probe.Result
+	}{
+		{
+			name:   "mysql handshake",
+			handle: func(conn net.Conn) { _, _ = conn.Write(mysqlPacket("\x0a8.0.33\x00rest")) },
+			run:    func(port int) (probe.Result, string, error) { return mysqlProbe("127.0.0.1", port, time.Second) },
+			want:   probe.Success,
+		},
+		{
+			name:   "mysql too many connections",
+			handle: func(conn net.Conn) { _, _ = conn.Write(mysqlPacket("\xff\x10\x04Too many connections")) },
+			run:    func(port int) (probe.Result, string, error) { return mysqlProbe("127.0.0.1", port, time.Second) },
+			want:   probe.Failure,
+		},
+		{
+			name: "mysql oversized packet",
+			handle: func(conn net.Conn) {
+				_, _ = conn.Write([]byte{0xff, 0xff, 0xff, 0})
+			},
+			run: func(port int) (probe.Result, string, error) {
+				return mysqlProbe("127.0.0.1", port, time.Second)
+			},
+			want: probe.Failure,
+		},
+		{
+			name: "postgresql accepting",
+			handle: func(conn net.Conn) {
+				_, _ = io.ReadFull(conn, make([]byte, 8))
+				_, _ = conn.Write([]byte("N"))
+				_, _ = conn.Read(make([]byte, 256))
+				_, _ = conn.Write(postgresMessage('R', "\x00\x00\x00\x05salt"))
+			},
+			run:  func(port int) (probe.Result, string, error) { return postgresProbe("127.0.0.1", port, nil, time.Second) },
+			want: probe.Success,
+		},
+		{
+			name: "postgresql starting up",
+			handle: func(conn net.Conn) {
+				_, _ = io.ReadFull(conn, make([]byte, 8))
+				_, _ = conn.Write([]byte("N"))
+				_, _ = conn.Read(make([]byte, 256))
+				_, _ = conn.Write(postgresMessage('E', "SFATAL\x00C57P03\x00Mthe database system is starting up\x00\x00"))
+			},
+			run:  func(port int) (probe.Result, string, error) { return postgresProbe("127.0.0.1", port, nil, time.Second) },
+			want: probe.Failure,
+		},
+		{
+			name: "postgresql without tls",
+			handle: func(conn net.Conn) {
+				_, _ = io.ReadFull(conn, make([]byte, 8))
+				_, _ = conn.Write([]byte("N"))
+			},
+			run: func(port int) (probe.Result, string, error) {
+				return postgresProbe("127.0.0.1", port, &tls.Config{MinVersion: tls.VersionTLS12}, time.Second)
+			},
+			want: probe.Failure,
+		},
+		{
+			name: "redis auth and ping",
+			handle: func(conn net.Conn) {
+				r := bufio.NewReader(conn)
+				for _, reply := range []string{"+OK\r\n", "+PONG\r\n"} {
+					// Every command is an array of bulk strings.
+					line, _ := r.ReadString('\n')
+					for i := 0; i < 2*int(line[1]-'0'); i++ {
+						_, _ = r.ReadString('\n')
+					}
+					_, _ = conn.Write([]byte(reply))
+				}
+			},
+			run: func(port int) (probe.Result, string, error) {
+				return redisProbe("127.0.0.1", port, "", []byte("secret"), time.Second)
+			},
+			want: probe.Success,
+		},
+		{
+			name: "redis loading",
+			handle: func(conn net.Conn) {
+				_, _ = conn.Read(make([]byte, 256))
+				_, _ = conn.Write([]byte("-LOADING Redis is loading the dataset in memory\r\n"))
+			},
+			run:  func(port int) (probe.Result, string, error) { return redisProbe("127.0.0.1", port, "", nil, time.Second) },
+			want: probe.Failure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, output, err := tt.run(serveOnce(t, tt.handle))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got = %v (%q), want %v", got, output, tt.want)
+			}
+			if got == probe.Failure && strings.TrimSpace(output) == "" {
+				t.Error("expected the failure to be explained")
+			}
+		})
+	}
+}
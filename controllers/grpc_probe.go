@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/labring/operator-sdk/probe"
+	"github.com/labring/operator-sdk/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcProber runs the gRPC health protocol like the sdk prober, over TLS when a config is given.
+type grpcProber struct{}
+
+// Probe checks the health of the service, any failure is considered as a probe failure.
+func (grpcProber) Probe(host, service string, port int, tlsConfig *tls.Config, timeout time.Duration) (probe.Result, string, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	opts := []grpc.DialOption{
+		grpc.WithUserAgent(fmt.Sprintf("kube-probe/%s", version.Get().GitVersion)),
+		grpc.WithBlock(),
+		grpc.WithTransportCredentials(creds),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return probe.Failure, fmt.Sprintf("timeout: failed to connect service %q within %v: %+v", addr, timeout, err), nil
+		}
+		return probe.Failure, fmt.Sprintf("error: failed to connect service at %q: %+v", addr, err), nil
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	resp, err := grpchealth.NewHealthClient(conn).Check(ctx, &grpchealth.HealthCheckRequest{Service: service})
+	if err != nil {
+		if stat, ok := status.FromError(err); ok {
+			switch stat.Code() {
+			case codes.Unimplemented:
+				return probe.Failure, fmt.Sprintf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health): %s", stat.Message()), nil
+			case codes.DeadlineExceeded:
+				return probe.Failure, fmt.Sprintf("timeout: health rpc did not complete within %v", timeout), nil
+			}
+		}
+		return probe.Failure, fmt.Sprintf("error: health rpc probe failed: %+v", err), nil
+	}
+	if resp.GetStatus() != grpchealth.HealthCheckResponse_SERVING {
+		return probe.Failure, fmt.Sprintf("service unhealthy (responded with %q)", resp.GetStatus().String()), nil
+	}
+	return probe.Success, "service healthy", nil
+}
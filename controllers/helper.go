@@ -19,9 +19,16 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -46,6 +53,56 @@ func (c *Reconciler) updateStatus(ctx context.Context, nn types.NamespacedName,
 	}
 	return nil
 }
+
+// statusNeedsUpdate reports whether the status has to be written. Changes of the health of the hosts and of
+// the conditions are written right away, the probe details that change on every probe at most every statusRefreshInterval.
+func statusNeedsUpdate(current, desired *v1beta1.ClusterEndpointStatus, now time.Time) bool {
+	if !equality.Semantic.DeepEqual(withoutProbeDetails(current), withoutProbeDetails(desired)) {
+		return true
+	}
+	if equality.Semantic.DeepEqual(current, desired) {
+		return false
+	}
+	// The heartbeats are refreshed on every write, the one of Ready tells when the status was written last.
+	for _, condition := range current.Conditions {
+		if condition.Type == v1beta1.Ready {
+			return now.Sub(condition.LastHeartbeatTime.Time) >= statusRefreshInterval
+		}
+	}
+	return true
+}
+
+// withoutProbeDetails returns a copy of the status without the fields that change on every probe.
+// The errors are left out as well, they carry the local port of the connection and differ on every failed probe.
+func withoutProbeDetails(status *v1beta1.ClusterEndpointStatus) *v1beta1.ClusterEndpointStatus {
+	status = status.DeepCopy()
+	for i := range status.Hosts {
+		host := &status.Hosts[i]
+		host.LastProbeTime = metav1.Time{}
+		host.LastProbeLatency = metav1.Duration{}
+		host.ConsecutiveFailures = 0
+		host.LastError = ""
+		for j := range host.Checks {
+			host.Checks[j].LastProbeLatency = metav1.Duration{}
+			host.Checks[j].LastError = ""
+		}
+		if host.Certificate != nil {
+			host.Certificate.LastError = ""
+		}
+	}
+	for i := range status.Conditions {
+		status.Conditions[i].LastHeartbeatTime = metav1.Time{}
+	}
+	return status
+}
+
+// refreshHeartbeats sets the heartbeat of all the conditions to now, before the status is written.
+func refreshHeartbeats(status *v1beta1.ClusterEndpointStatus, now metav1.Time) {
+	for i := range status.Conditions {
+		status.Conditions[i].LastHeartbeatTime = now
+	}
+}
+
 func (c *Reconciler) syncFinalStatus(cep *v1beta1.ClusterEndpoint) {
 	clusterReadyCondition := v1beta1.Condition{
 		Type:               v1beta1.Ready,
@@ -67,29 +124,209 @@ func (c *Reconciler) syncFinalStatus(cep *v1beta1.ClusterEndpoint) {
 	c.updateCondition(cep, clusterReadyCondition)
 }
 
-type healthyHostAndPort struct {
-	sps  []v1beta1.ServicePort
-	host string
+// repackSubsets groups the addresses with the same ports and IP family into one subset, like the endpoints
+// controller does. Addresses, ports and subsets are sorted, so that the same endpoints always give the same subsets.
+func repackSubsets(subsets []v1.EndpointSubset) []v1.EndpointSubset {
+	type address struct {
+		ip    string
+		ready bool
+	}
+	var order []address
+	addressPorts := make(map[address]map[string]v1.EndpointPort)
+	add := func(addr v1.EndpointAddress, ready bool, ports []v1.EndpointPort) {
+		a := address{ip: addr.IP, ready: ready}
+		if addressPorts[a] == nil {
+			addressPorts[a] = make(map[string]v1.EndpointPort)
+			order = append(order, a)
+		}
+		for _, port := range ports {
+			// The API server defaults the protocol, so that the Endpoints compare equal to what we write.
+			port.Protocol = *protocolPtr(port.Protocol)
+			addressPorts[a][endpointPortKey(port)] = port
+		}
+	}
+	for _, subset := range subsets {
+		for _, addr := range subset.Addresses {
+			add(addr, true, subset.Ports)
+		}
+		for _, addr := range subset.NotReadyAddresses {
+			add(addr, false, subset.Ports)
+		}
+	}
+
+	var keys []string
+	groups := make(map[string]*v1.EndpointSubset)
+	for _, a := range order {
+		ports := addressPorts[a]
+		if len(ports) == 0 {
+			continue
+		}
+		portKeys := make([]string, 0, len(ports))
+		for key := range ports {
+			portKeys = append(portKeys, key)
+		}
+		sort.Strings(portKeys)
+		key := string(addressTypeOf(a.ip)) + "/" + strings.Join(portKeys, ",")
+		subset, ok := groups[key]
+		if !ok {
+			subset = &v1.EndpointSubset{}
+			for _, portKey := range portKeys {
+				subset.Ports = append(subset.Ports, ports[portKey])
+			}
+			groups[key] = subset
+			keys = append(keys, key)
+		}
+		if a.ready {
+			subset.Addresses = append(subset.Addresses, v1.EndpointAddress{IP: a.ip})
+		} else {
+			subset.NotReadyAddresses = append(subset.NotReadyAddresses, v1.EndpointAddress{IP: a.ip})
+		}
+	}
+
+	sort.Strings(keys)
+	var repacked []v1.EndpointSubset
+	for _, key := range keys {
+		subset := groups[key]
+		sort.Slice(subset.Addresses, func(i, j int) bool { return subset.Addresses[i].IP < subset.Addresses[j].IP })
+		sort.Slice(subset.NotReadyAddresses, func(i, j int) bool { return subset.NotReadyAddresses[i].IP < subset.NotReadyAddresses[j].IP })
+		repacked = append(repacked, *subset)
+	}
+	return repacked
+}
+
+func endpointPortKey(port v1.EndpointPort) string {
+	return fmt.Sprintf("%s/%s/%d", port.Name, port.Protocol, port.Port)
 }
 
-func (hap *healthyHostAndPort) toEndpoint() v1.EndpointSubset {
-	s := make([]v1.EndpointPort, 0)
-	for _, sp := range hap.sps {
-		endPoint := v1.EndpointPort{
-			Name:     sp.Name,
-			Port:     sp.TargetPort,
-			Protocol: sp.Protocol,
+// isEndpointPublished reports whether the host of the port is part of the subsets.
+func isEndpointPublished(subsets []v1.EndpointSubset, sp v1beta1.ServicePort, host string) bool {
+	for _, subset := range subsets {
+		hasPort := false
+		for _, port := range subset.Ports {
+			if port.Name == sp.Name && port.Port == sp.TargetPort {
+				hasPort = true
+				break
+			}
+		}
+		if !hasPort {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			if addr.IP == host {
+				return true
+			}
 		}
-		s = append(s, endPoint)
 	}
-	return v1.EndpointSubset{
-		Addresses: []v1.EndpointAddress{
-			{
-				IP: hap.host,
-			},
-		},
-		Ports: s,
+	return false
+}
+
+// publishedAddresses returns the ready addresses of the port in the subsets.
+func publishedAddresses(subsets []v1.EndpointSubset, sp v1beta1.ServicePort) []string {
+	var addresses []string
+	for _, subset := range subsets {
+		for _, port := range subset.Ports {
+			if port.Name == sp.Name && port.Port == sp.TargetPort {
+				for _, addr := range subset.Addresses {
+					addresses = append(addresses, addr.IP)
+				}
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// splitHost returns the port with the target port of the host, and the IP address or DNS name of the host.
+// A host can override the target port of the port as host:port, or [host]:port for IPv6 addresses.
+func splitHost(sp v1beta1.ServicePort, host string) (v1beta1.ServicePort, string) {
+	if net.ParseIP(host) != nil {
+		return sp, host
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return sp, host
+	}
+	targetPort, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || targetPort < 1 || targetPort > 65535 {
+		return sp, host
+	}
+	sp.TargetPort = int32(targetPort)
+	return sp, name
+}
+
+// validateHost rejects the hosts splitHost would take for a DNS name by mistake:
+// an IPv6 address in brackets without a port, a named port, and a port out of the range 1 to 65535.
+func validateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if strings.HasPrefix(host, "[") {
+		name, _, err := net.SplitHostPort(host)
+		if err != nil || net.ParseIP(name) == nil {
+			return fmt.Errorf("host %s is not an IPv6 address given as [host]:port", host)
+		}
 	}
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		targetPort, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return fmt.Errorf("port %s of host %s is not a number, named ports are not supported", port, host)
+		}
+		if targetPort < 1 || targetPort > 65535 {
+			return fmt.Errorf("port of host %s is not in the range 1 to 65535", host)
+		}
+	}
+	return nil
+}
+
+// isDrained reports whether the address of the key is drained, by its host as given in the spec,
+// by the address or by address:port.
+func isDrained(drained sets.String, host string, key probeKey) bool {
+	return drained.Has(host) || drained.Has(key.address) || drained.Has(key.instance())
+}
+
+// drainedHosts returns the hosts and addresses drained by the spec or the annotation.
+func drainedHosts(cep *v1beta1.ClusterEndpoint) sets.String {
+	drained := sets.NewString(cep.Spec.DrainedHosts...)
+	for _, host := range strings.Split(cep.Annotations[v1beta1.DrainAnnotation], ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			drained.Insert(host)
+		}
+	}
+	return drained
+}
+
+// ipFamilyOf returns the IP family of the address. IPv4-mapped IPv6 addresses are refused,
+// they fit in the EndpointSlices of neither family.
+func ipFamilyOf(address string) (v1.IPFamily, error) {
+	ip, err := netip.ParseAddr(address)
+	if err != nil || ip.Zone() != "" {
+		return "", fmt.Errorf("invalid IP address %q", address)
+	}
+	switch {
+	case ip.Is4():
+		return v1.IPv4Protocol, nil
+	case ip.Is4In6():
+		return "", fmt.Errorf("IPv4-mapped IPv6 address %s is not supported", address)
+	}
+	return v1.IPv6Protocol, nil
+}
+
+// servesAddress checks that the address is of one of the ipFamilies of the ClusterEndpoint,
+// all families are served when none is set.
+func servesAddress(cep *v1beta1.ClusterEndpoint, address string) error {
+	family, err := ipFamilyOf(address)
+	if err != nil {
+		return err
+	}
+	if len(cep.Spec.IPFamilies) == 0 {
+		return nil
+	}
+	for _, f := range cep.Spec.IPFamilies {
+		if f == family {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s address %s is not one of the ipFamilies %v", family, address, cep.Spec.IPFamilies)
 }
 
 // ToAggregate converts the ErrorList into an errors.Aggregate.
@@ -116,8 +353,9 @@ func convertServicePorts(sps []v1beta1.ServicePort) []v1.ServicePort {
 			endPoint := v1.ServicePort{
 				Name:       sp.Name,
 				Port:       sp.Port,
-				Protocol:   sp.Protocol,
+				Protocol:   *protocolPtr(sp.Protocol),
 				TargetPort: intstr.FromString(sp.Name),
+				NodePort:   sp.NodePort,
 			}
 			s = append(s, endPoint)
 		}
@@ -141,6 +379,10 @@ func isConditionsTrue(ce *v1beta1.ClusterEndpoint) bool {
 		if condition.Type == v1beta1.Ready {
 			continue
 		}
+		// Degraded is only reported while it is true.
+		if condition.Type == v1beta1.Degraded {
+			return false
+		}
 		if condition.Status != v1.ConditionTrue {
 			return false
 		}
@@ -159,6 +401,9 @@ func (c *Reconciler) updateCondition(cep *v1beta1.ClusterEndpoint, condition v1b
 		if cond.Type == condition.Type {
 			hasCondition = true
 			if cond.Reason != condition.Reason || cond.Status != condition.Status || cond.Message != condition.Message {
+				if cond.Status == condition.Status {
+					condition.LastTransitionTime = cond.LastTransitionTime
+				}
 				cep.Status.Conditions[i] = condition
 			}
 		}
@@ -167,3 +412,14 @@ func (c *Reconciler) updateCondition(cep *v1beta1.ClusterEndpoint, condition v1b
 		cep.Status.Conditions = append(cep.Status.Conditions, condition)
 	}
 }
+
+// removeCondition drops the condition of the given type from the cluster conditions.
+func removeCondition(cep *v1beta1.ClusterEndpoint, conditionType v1beta1.ConditionType) {
+	conditions := cep.Status.Conditions[:0]
+	for _, cond := range cep.Status.Conditions {
+		if cond.Type != conditionType {
+			conditions = append(conditions, cond)
+		}
+	}
+	cep.Status.Conditions = conditions
+}
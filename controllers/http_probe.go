@@ -0,0 +1,226 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+	"github.com/labring/operator-sdk/version"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultMaxRespBodyLength = 10 * 1 << 10 // 10KB
+	maxRespBodyLength        = 1 << 20      // 1MB
+)
+
+// httpProber runs HTTP probes with the assertions of v1beta1.HTTPGetAction,
+// which the sdk prober does not support.
+type httpProber struct {
+	// transport is used when no TLS config is given, it skips the verification of the server.
+	transport *http.Transport
+}
+
+func newHTTPProber() *httpProber {
+	return &httpProber{transport: newHTTPTransport(&tls.Config{InsecureSkipVerify: true})} // nolint: gosec
+}
+
+func newHTTPTransport(config *tls.Config) *http.Transport {
+	// We do not want the probe use node's local proxy set.
+	return utilnet.SetTransportDefaults(
+		&http.Transport{
+			TLSClientConfig:    config,
+			DisableKeepAlives:  true,
+			DisableCompression: true,
+			Proxy:              http.ProxyURL(nil),
+		})
+}
+
+// Probe sends the request of the action to the url, and checks the status code and the body of the response.
+func (pr *httpProber) Probe(u *url.URL, action *v1beta1.HTTPGetAction, tlsConfig *tls.Config, timeout time.Duration) (probe.Result, string, error) {
+	statuses, err := parseStatusRanges(action.ExpectedStatuses)
+	if err != nil {
+		return probe.Unknown, "", err
+	}
+	method := action.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if action.Body != "" {
+		body = strings.NewReader(action.Body)
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		// Convert errors into failures to catch timeouts.
+		return probe.Failure, err.Error(), nil
+	}
+	headers := buildHeader(action.HTTPHeaders)
+	if _, ok := headers["User-Agent"]; !ok {
+		// explicitly set User-Agent so it's not set to default Go value
+		headers.Set("User-Agent", fmt.Sprintf("kube-probe/%s", version.Get().GitVersion))
+	}
+	if _, ok := headers["Accept"]; !ok {
+		headers.Set("Accept", "*/*")
+	} else if headers.Get("Accept") == "" {
+		headers.Del("Accept")
+	}
+	req.Header = headers
+	req.Host = headers.Get("Host")
+
+	transport := pr.transport
+	if tlsConfig != nil {
+		transport = newHTTPTransport(tlsConfig)
+	}
+	client := &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: localRedirectChecker,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		// Convert errors into failures to catch timeouts.
+		return probe.Failure, err.Error(), nil
+	}
+	defer res.Body.Close()
+
+	limit := int64(defaultMaxRespBodyLength)
+	if action.ResponseBody != nil && action.ResponseBody.MaxBytes > 0 {
+		limit = int64(action.ResponseBody.MaxBytes)
+	}
+	if limit > maxRespBodyLength {
+		limit = maxRespBodyLength
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, limit))
+	if err != nil {
+		return probe.Failure, "", err
+	}
+	if !statuses.contains(res.StatusCode) {
+		klog.V(4).Infof("Probe failed for %s with request headers %v, response body: %v", u.String(), headers, string(b))
+		return probe.Failure, fmt.Sprintf("HTTP probe failed with statuscode: %d", res.StatusCode), nil
+	}
+	if action.ResponseBody != nil {
+		if msg, err := checkResponseBody(action.ResponseBody, b); err != nil || msg != "" {
+			return probe.Failure, msg, err
+		}
+	}
+	klog.V(4).Infof("Probe succeeded for %s, Response: %v", u.String(), *res)
+	return probe.Success, string(b), nil
+}
+
+// checkResponseBody returns why the body does not match the assertions, or an empty string if it does.
+func checkResponseBody(assert *v1beta1.HTTPResponseBody, body []byte) (string, error) {
+	if assert.Contains != "" && !bytes.Contains(body, []byte(assert.Contains)) {
+		return fmt.Sprintf("HTTP probe failed: response body does not contain %q", assert.Contains), nil
+	}
+	if assert.Regex != "" {
+		re, err := regexp.Compile(assert.Regex)
+		if err != nil {
+			return "", err
+		}
+		if !re.Match(body) {
+			return fmt.Sprintf("HTTP probe failed: response body does not match %q", assert.Regex), nil
+		}
+	}
+	if assert.JSONPath != "" {
+		j := jsonpath.New("responseBody")
+		if err := j.Parse(assert.JSONPath); err != nil {
+			return "", err
+		}
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return fmt.Sprintf("HTTP probe failed: response body is not JSON: %v", err), nil
+		}
+		buf := &bytes.Buffer{}
+		if err := j.Execute(buf, data); err != nil {
+			return fmt.Sprintf("HTTP probe failed: %v", err), nil
+		}
+		got := buf.String()
+		if assert.Value != "" && got != assert.Value {
+			return fmt.Sprintf("HTTP probe failed: %s is %q, expected %q", assert.JSONPath, got, assert.Value), nil
+		}
+		if got == "" {
+			return fmt.Sprintf("HTTP probe failed: %s is empty", assert.JSONPath), nil
+		}
+	}
+	return "", nil
+}
+
+type statusRange struct {
+	from, to int
+}
+
+type statusRanges []statusRange
+
+func (r statusRanges) contains(code int) bool {
+	for _, s := range r {
+		if code >= s.from && code <= s.to {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusRanges parses codes such as "200" and ranges such as "200-299", it defaults to 200-399.
+func parseStatusRanges(statuses []string) (statusRanges, error) {
+	if len(statuses) == 0 {
+		return statusRanges{{from: http.StatusOK, to: http.StatusBadRequest - 1}}, nil
+	}
+	ranges := make(statusRanges, 0, len(statuses))
+	for _, status := range statuses {
+		from, to, isRange := strings.Cut(strings.TrimSpace(status), "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected status %q", status)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < start {
+				return nil, fmt.Errorf("invalid expected status %q", status)
+			}
+		}
+		ranges = append(ranges, statusRange{from: start, to: end})
+	}
+	return ranges, nil
+}
+
+// localRedirectChecker follows redirects on the same host only, like the kubelet.
+func localRedirectChecker(req *http.Request, via []*http.Request) error {
+	if req.URL.Hostname() != via[0].URL.Hostname() {
+		return http.ErrUseLastResponse
+	}
+	// Default behavior: stop after 10 redirects.
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
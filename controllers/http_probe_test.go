@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+)
+
+func TestHTTPProber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			_, _ = w.Write([]byte(`{"status":"DOWN"}`))
+		case "/echo":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			_, _ = io.Copy(w, r.Body)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte(`{"status":"UP"}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		action v1beta1.HTTPGetAction
+		want   probe.Result
+	}{
+		{name: "default", path: "/down", want: probe.Success},
+		{name: "json path", path: "/up", action: v1beta1.HTTPGetAction{ResponseBody: &v1beta1.HTTPResponseBody{JSONPath: "{.status}", Value: "UP"}}, want: probe.Success},
+		{name: "json path mismatch", path: "/down", action: v1beta1.HTTPGetAction{ResponseBody: &v1beta1.HTTPResponseBody{JSONPath: "{.status}", Value: "UP"}}, want: probe.Failure},
+		{name: "contains", path: "/down", action: v1beta1.HTTPGetAction{ResponseBody: &v1beta1.HTTPResponseBody{Contains: "UP"}}, want: probe.Failure},
+		{name: "regex", path: "/up", action: v1beta1.HTTPGetAction{ResponseBody: &v1beta1.HTTPResponseBody{Regex: `"status":\s*"UP"`}}, want: probe.Success},
+		{name: "post body", path: "/echo", action: v1beta1.HTTPGetAction{Method: http.MethodPost, Body: "pong", ResponseBody: &v1beta1.HTTPResponseBody{Contains: "pong"}}, want: probe.Success},
+		{name: "status not expected", path: "/missing", want: probe.Failure},
+		{name: "status expected", path: "/missing", action: v1beta1.HTTPGetAction{ExpectedStatuses: []string{"200", "400-404"}}, want: probe.Success},
+	}
+	pr := newHTTPProber()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(srv.URL + tt.path)
+			got, output, err := pr.Probe(u, &tt.action, nil, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Probe() got = %v (%q), want %v", got, output, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProberTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	tests := []struct {
+		name     string
+		cfg      v1beta1.TLSConfig
+		material portMaterial
+		want     probe.Result
+	}{
+		{name: "ca", cfg: v1beta1.TLSConfig{ServerName: "example.com"}, material: portMaterial{ca: ca}, want: probe.Success},
+		{name: "system roots", material: portMaterial{}, want: probe.Failure},
+		{name: "insecure", cfg: v1beta1.TLSConfig{InsecureSkipVerify: true}, want: probe.Success},
+	}
+	pr := newHTTPProber()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildTLSConfig(&tt.cfg, &tt.material, "127.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(srv.URL)
+			got, output, err := pr.Probe(u, &v1beta1.HTTPGetAction{}, config, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Probe() got = %v (%q), want %v", got, output, tt.want)
+			}
+		})
+	}
+}
@@ -19,11 +19,13 @@ package controllers
 import (
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8sruntime "k8s.io/apimachinery/pkg/util/runtime"
 )
 
 func Install(scheme *runtime.Scheme) {
 	k8sruntime.Must(v1.AddToScheme(scheme))
+	k8sruntime.Must(discoveryv1.AddToScheme(scheme))
 	k8sruntime.Must(v1beta1.Install(scheme))
 }
@@ -0,0 +1,217 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// CacheOptions restricts the Secrets and ConfigMaps cached by the manager to the ones with the ProbeMaterialLabel,
+// so that the material of the probes is read from the cache without caching every Secret of the cluster.
+func CacheOptions() cache.Options {
+	selector := labels.SelectorFromSet(labels.Set{v1beta1.ProbeMaterialLabel: "true"})
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}:    {Label: selector},
+			&corev1.ConfigMap{}: {Label: selector},
+		},
+	}
+}
+
+// portKey identifies a check of a port of a ClusterEndpoint, the check is empty for the handler of the port.
+type portKey struct {
+	name       string
+	targetPort int32
+	check      string
+}
+
+// portMaterial is the content of the Secrets and ConfigMaps referred to by the handler of a check.
+// Workers only see the material, so that they never have to talk to the API server.
+type portMaterial struct {
+	// ca, cert and key are the TLS material.
+	ca   []byte
+	cert []byte
+	key  []byte
+	// password is sent by the Redis probe.
+	password []byte
+	// err is why the material could not be loaded, the probes of the port fail with it.
+	err string
+}
+
+// loadPortMaterials reads from the cache the material of every check of the ClusterEndpoint that refers to Secrets or ConfigMaps.
+func (c *Reconciler) loadPortMaterials(ctx context.Context, cep *v1beta1.ClusterEndpoint) map[portKey]*portMaterial {
+	materials := make(map[portKey]*portMaterial)
+	for _, port := range cep.Spec.Ports {
+		for _, check := range portChecks(port) {
+			cfg := handlerTLSConfig(check.Handler)
+			var passwordSecret *corev1.SecretKeySelector
+			if check.Redis != nil && check.Redis.Enable {
+				passwordSecret = check.Redis.PasswordSecret
+			}
+			if cfg == nil && passwordSecret == nil {
+				continue
+			}
+			m, err := c.loadPortMaterial(ctx, cep.Namespace, cfg, passwordSecret)
+			if err != nil {
+				c.logger.V(4).Info("error loading port material", "name", cep.Name, "port", port.Name, "check", check.Name, "msg", err.Error())
+				m = &portMaterial{err: err.Error()}
+			}
+			materials[portKeyOf(port, check.Name)] = m
+		}
+	}
+	return materials
+}
+
+func (c *Reconciler) loadPortMaterial(ctx context.Context, namespace string, cfg *v1beta1.TLSConfig, passwordSecret *corev1.SecretKeySelector) (*portMaterial, error) {
+	m := &portMaterial{}
+	var err error
+	if cfg != nil {
+		if m.ca, err = c.readTLSSelector(ctx, namespace, cfg.CA); err != nil {
+			return nil, err
+		}
+		if m.cert, err = c.readTLSSelector(ctx, namespace, cfg.Cert); err != nil {
+			return nil, err
+		}
+		if m.key, err = c.readSecretKey(ctx, namespace, cfg.KeySecret); err != nil {
+			return nil, err
+		}
+	}
+	if m.password, err = c.readSecretKey(ctx, namespace, passwordSecret); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *Reconciler) readTLSSelector(ctx context.Context, namespace string, sel *v1beta1.TLSSelector) ([]byte, error) {
+	if sel == nil {
+		return nil, nil
+	}
+	if sel.Secret != nil && sel.ConfigMap != nil {
+		return nil, errors.New("only one of secret and configMap may be set")
+	}
+	if sel.Secret != nil {
+		return c.readSecretKey(ctx, namespace, sel.Secret)
+	}
+	if sel.ConfigMap != nil {
+		cm := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: sel.ConfigMap.Name}, cm); err != nil {
+			return nil, materialError(err, "configmap", sel.ConfigMap.Name)
+		}
+		if data, ok := cm.Data[sel.ConfigMap.Key]; ok {
+			return []byte(data), nil
+		}
+		if data, ok := cm.BinaryData[sel.ConfigMap.Key]; ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("key %s not found in configmap %s", sel.ConfigMap.Key, sel.ConfigMap.Name)
+	}
+	return nil, nil
+}
+
+func (c *Reconciler) readSecretKey(ctx context.Context, namespace string, sel *corev1.SecretKeySelector) ([]byte, error) {
+	if sel == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: sel.Name}, secret); err != nil {
+		return nil, materialError(err, "secret", sel.Name)
+	}
+	// The cache only holds labeled Secrets, check the label anyway: it is the consent of the owner of
+	// the Secret to have it sent to the hosts of any ClusterEndpoint of the namespace.
+	if secret.Labels[v1beta1.ProbeMaterialLabel] != "true" {
+		return nil, fmt.Errorf("secret %s needs the label %s=true", sel.Name, v1beta1.ProbeMaterialLabel)
+	}
+	data, ok := secret.Data[sel.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in secret %s", sel.Key, sel.Name)
+	}
+	return data, nil
+}
+
+// materialError explains that Secrets and ConfigMaps without the ProbeMaterialLabel are not found.
+func materialError(err error, kind, name string) error {
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%s %s not found, it needs the label %s=true", kind, name, v1beta1.ProbeMaterialLabel)
+	}
+	return err
+}
+
+// materialRequests returns the ClusterEndpoints of the namespace of the Secret or ConfigMap that refer to it,
+// so that they pick up its changes.
+func (c *Reconciler) materialRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	_, isSecret := obj.(*corev1.Secret)
+	ceps := &v1beta1.ClusterEndpointList{}
+	if err := c.List(ctx, ceps, client.InNamespace(obj.GetNamespace())); err != nil {
+		c.logger.V(4).Info("error listing cluster endpoints", "namespace", obj.GetNamespace(), "msg", err.Error())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range ceps.Items {
+		cep := &ceps.Items[i]
+		if refersToMaterial(cep, isSecret, obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cep)})
+		}
+	}
+	return requests
+}
+
+// refersToMaterial reports whether a check of the ClusterEndpoint reads the Secret, or the ConfigMap, of the name.
+func refersToMaterial(cep *v1beta1.ClusterEndpoint, secret bool, name string) bool {
+	for _, port := range cep.Spec.Ports {
+		for _, check := range portChecks(port) {
+			var secrets []*corev1.SecretKeySelector
+			var configMaps []*corev1.ConfigMapKeySelector
+			if cfg := handlerTLSConfig(check.Handler); cfg != nil {
+				secrets = append(secrets, cfg.KeySecret)
+				for _, sel := range []*v1beta1.TLSSelector{cfg.CA, cfg.Cert} {
+					if sel != nil {
+						secrets = append(secrets, sel.Secret)
+						configMaps = append(configMaps, sel.ConfigMap)
+					}
+				}
+			}
+			if check.Redis != nil {
+				secrets = append(secrets, check.Redis.PasswordSecret)
+			}
+			if secret {
+				for _, sel := range secrets {
+					if sel != nil && sel.Name == name {
+						return true
+					}
+				}
+				continue
+			}
+			for _, sel := range configMaps {
+				if sel != nil && sel.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
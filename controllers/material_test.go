@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_refersToMaterial(t *testing.T) {
+	cep := &v1beta1.ClusterEndpoint{Spec: v1beta1.ClusterEndpointSpec{Ports: []v1beta1.ServicePort{{
+		Checks: []v1beta1.Check{
+			{Name: "grpc", Handler: v1beta1.Handler{GRPC: &v1beta1.GRPCAction{Enable: true, TLS: &v1beta1.TLSConfig{
+				CA:        &v1beta1.TLSSelector{ConfigMap: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "upstream-ca"}, Key: "ca.crt"}},
+				KeySecret: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "probe-client"}, Key: "tls.key"},
+			}}}},
+			{Name: "redis", Handler: v1beta1.Handler{Redis: &v1beta1.RedisAction{Enable: true,
+				PasswordSecret: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "redis-auth"}, Key: "password"},
+			}}},
+		},
+	}}}}
+	tests := []struct {
+		secret bool
+		name   string
+		want   bool
+	}{
+		{secret: false, name: "upstream-ca", want: true},
+		{secret: true, name: "upstream-ca", want: false},
+		{secret: true, name: "probe-client", want: true},
+		{secret: true, name: "redis-auth", want: true},
+		{secret: false, name: "redis-auth", want: false},
+		{secret: true, name: "other", want: false},
+	}
+	for _, tt := range tests {
+		if got := refersToMaterial(cep, tt.secret, tt.name); got != tt.want {
+			t.Errorf("refersToMaterial(secret %v, %s) = %v, want %v", tt.secret, tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_readSecretKey(t *testing.T) {
+	secret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		}
+	}
+	r := &Reconciler{Client: fake.NewClientBuilder().WithObjects(
+		secret("labeled", map[string]string{v1beta1.ProbeMaterialLabel: "true"}),
+		secret("unlabeled", nil),
+	).Build()}
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "labeled", wantErr: false},
+		{name: "unlabeled", wantErr: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		sel := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: tt.name}, Key: "password"}
+		data, err := r.readSecretKey(context.Background(), "default", sel)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readSecretKey(%s) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && string(data) != "secret" {
+			t.Errorf("readSecretKey(%s) = %q, want %q", tt.name, data, "secret")
+		}
+	}
+}
@@ -0,0 +1,543 @@
+/*
+Copyright 2022 The sealos Authors.
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/endpoints-operator/utils/dns"
+	"github.com/labring/endpoints-operator/utils/metrics"
+	libv1 "github.com/labring/operator-sdk/api/core/v1"
+	"github.com/labring/operator-sdk/probe"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	defaultPeriodSeconds     = 10
+	defaultFlapWindowSeconds = 300
+)
+
+var errExecProbeDisabled = errors.New("exec probes are disabled, the operator has to be started with --enable-exec-probe")
+
+// probeKey identifies a single address of a host of a ClusterEndpoint port.
+// The address is the host itself unless the host is a DNS name.
+type probeKey struct {
+	types.NamespacedName
+	portName   string
+	targetPort int32
+	host       string
+	address    string
+}
+
+// withAddress returns the key of one of the addresses the host resolved to.
+func (k probeKey) withAddress(address string) probeKey {
+	k.address = address
+	return k
+}
+
+// instance returns the address and the port of the key, as host:port or [host]:port for IPv6.
+func (k probeKey) instance() string {
+	return net.JoinHostPort(k.address, strconv.Itoa(int(k.targetPort)))
+}
+
+// sameHost reports whether both keys belong to the same host.
+func (k probeKey) sameHost(o probeKey) bool {
+	return k.NamespacedName == o.NamespacedName && k.portName == o.portName && k.targetPort == o.targetPort && k.host == o.host
+}
+
+// probeResult is the cached health of a host.
+type probeResult struct {
+	// initialized is set once the success or failure threshold has been reached,
+	// ready is only meaningful after that.
+	initialized bool
+	ready       bool
+	// healthy is whether the checks pass once the thresholds are reached, ready is healthy after damping.
+	healthy bool
+	err     error
+
+	lastProbeTime       time.Time
+	lastTransitionTime  time.Time
+	consecutiveFailures int32
+	latency             time.Duration
+	// certificate is the last certificate read from the address, nil when it is not checked.
+	certificate *certificateInfo
+	// checks are the results of the last run of each named check of the port.
+	checks []checkResult
+	// flapping is set while the address is held out because its health changed too often.
+	flapping bool
+}
+
+// checkResult is the result of the last run of a named check.
+type checkResult struct {
+	name    string
+	ready   bool
+	err     error
+	latency time.Duration
+}
+
+// resolution is the cached result of resolving a DNS name host.
+type resolution struct {
+	// addresses are sorted, they are kept from the last successful lookup when err is set.
+	addresses []string
+	err       error
+}
+
+// proberManager runs one long-lived worker per (ClusterEndpoint, port, host) and
+// caches their results, so that Reconcile never has to wait for a probe or a DNS lookup.
+type proberManager struct {
+	// Map of active workers for probes, grouped by ClusterEndpoint.
+	workers map[types.NamespacedName]map[probeKey]*worker
+	// Lock for accessing & mutating workers
+	workerLock sync.Mutex
+
+	results     map[probeKey]probeResult
+	resolutions map[probeKey]resolution
+	// owners are the workers allowed to write the results of their host. A worker that has been
+	// stopped may still be probing, its results must not bring back what was removed or replaced.
+	owners     map[probeKey]*worker
+	resultLock sync.RWMutex
+
+	// updates receives a ClusterEndpoint every time the health of one of its hosts flips.
+	updates chan event.GenericEvent
+
+	retry       int
+	metricsInfo *metrics.MetricsInfo
+	resolver    *dns.Resolver
+	// execEnabled allows exec probes. They run inside the operator container with its
+	// ServiceAccount, so that they are refused unless the operator is started with --enable-exec-probe.
+	execEnabled bool
+}
+
+func newProberManager(retry int, metricsInfo *metrics.MetricsInfo) *proberManager {
+	if retry <= 0 {
+		retry = 1
+	}
+	return &proberManager{
+		workers:     make(map[types.NamespacedName]map[probeKey]*worker),
+		results:     make(map[probeKey]probeResult),
+		resolutions: make(map[probeKey]resolution),
+		owners:      make(map[probeKey]*worker),
+		updates:     make(chan event.GenericEvent, 100),
+		retry:       retry,
+		metricsInfo: metricsInfo,
+		resolver:    dns.NewResolver(),
+	}
+}
+
+// Updates returns the channel on which ClusterEndpoints whose hosts changed health are sent.
+func (m *proberManager) Updates() <-chan event.GenericEvent {
+	return m.updates
+}
+
+// UpdateClusterEndpoint starts a worker for every probed or DNS name host of the ClusterEndpoint,
+// restarts the ones whose port or TLS material changed and stops the ones that are no longer needed.
+func (m *proberManager) UpdateClusterEndpoint(cep *v1beta1.ClusterEndpoint, materials map[portKey]*portMaterial) {
+	nn := client.ObjectKeyFromObject(cep)
+	period := time.Duration(cep.Spec.PeriodSeconds) * time.Second
+	if cep.Spec.PeriodSeconds <= 0 {
+		period = defaultPeriodSeconds * time.Second
+	}
+	desired := make(map[probeKey]workerConfig)
+	for _, port := range cep.Spec.Ports {
+		for _, host := range portHosts(port) {
+			key := newProbeKey(nn, port, host)
+			if validateHost(host) == nil && needsWorker(port, key.host) {
+				desired[key] = workerConfig{port: workerPort(port, key), materials: checkMaterials(port, materials)}
+			}
+		}
+	}
+
+	m.workerLock.Lock()
+	defer m.workerLock.Unlock()
+	workers := m.workers[nn]
+	if workers == nil {
+		workers = make(map[probeKey]*worker)
+	}
+	for key, w := range workers {
+		config, ok := desired[key]
+		if ok && w.period == period && reflect.DeepEqual(w.port, config.port) && reflect.DeepEqual(w.materials, config.materials) {
+			delete(desired, key)
+			continue
+		}
+		w.stop()
+		delete(workers, key)
+		if !ok {
+			m.removeResult(key)
+		}
+	}
+	for key, config := range desired {
+		w := newWorker(m, key, config.port, config.materials, period)
+		// Carry the health over when the port of a host changed.
+		w.loadResults()
+		workers[key] = w
+		go w.run()
+	}
+	if len(workers) == 0 {
+		delete(m.workers, nn)
+		return
+	}
+	m.workers[nn] = workers
+}
+
+// workerConfig is what a worker is started with, it is restarted when any of it changes.
+type workerConfig struct {
+	port      v1beta1.ServicePort
+	materials map[string]*portMaterial
+}
+
+// RemoveClusterEndpoint stops all the workers of the ClusterEndpoint and forgets their results.
+func (m *proberManager) RemoveClusterEndpoint(nn types.NamespacedName) {
+	m.workerLock.Lock()
+	defer m.workerLock.Unlock()
+	for key, w := range m.workers[nn] {
+		w.stop()
+		m.removeResult(key)
+	}
+	delete(m.workers, nn)
+}
+
+func (m *proberManager) getResult(key probeKey) (probeResult, bool) {
+	m.resultLock.RLock()
+	defer m.resultLock.RUnlock()
+	r, ok := m.results[key]
+	return r, ok
+}
+
+// setResult caches the result of the host and reports whether its health flipped.
+func (m *proberManager) setResult(key probeKey, r probeResult) bool {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	return m.storeResult(key, r)
+}
+
+// setWorkerResult is setResult for the worker of the host, the result is dropped once the worker no longer owns the host.
+func (m *proberManager) setWorkerResult(w *worker, key probeKey, r probeResult) bool {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	if m.owners[w.key] != w {
+		return false
+	}
+	return m.storeResult(key, r)
+}
+
+func (m *proberManager) storeResult(key probeKey, r probeResult) bool {
+	prev := m.results[key]
+	m.results[key] = r
+	return r.initialized && (!prev.initialized || prev.ready != r.ready)
+}
+
+// setOwner lets the worker write the results of its host, the previous worker of the host no longer can.
+func (m *proberManager) setOwner(w *worker) {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	m.owners[w.key] = w
+}
+
+// removeResult forgets the results of all the addresses of the host, and its resolution.
+func (m *proberManager) removeResult(key probeKey) {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	for k := range m.results {
+		if k.sameHost(key) {
+			delete(m.results, k)
+			m.forgetCertificate(k)
+		}
+	}
+	delete(m.resolutions, key)
+	delete(m.owners, key)
+}
+
+// removeTargetResult forgets the result of a single address of the host of the worker.
+func (m *proberManager) removeTargetResult(w *worker, key probeKey) {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	if m.owners[w.key] == w {
+		delete(m.results, key)
+		m.forgetCertificate(key)
+	}
+}
+
+func (m *proberManager) getResolution(key probeKey) (resolution, bool) {
+	m.resultLock.RLock()
+	defer m.resultLock.RUnlock()
+	r, ok := m.resolutions[key]
+	return r, ok
+}
+
+// setResolution caches the resolution of the host and reports whether its addresses
+// changed or its lookup started or stopped failing.
+func (m *proberManager) setResolution(key probeKey, r resolution) bool {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	return m.storeResolution(key, r)
+}
+
+// setWorkerResolution is setResolution for the worker of the host, the resolution is dropped once the worker no longer owns the host.
+func (m *proberManager) setWorkerResolution(w *worker, r resolution) bool {
+	m.resultLock.Lock()
+	defer m.resultLock.Unlock()
+	if m.owners[w.key] != w {
+		return false
+	}
+	return m.storeResolution(w.key, r)
+}
+
+func (m *proberManager) storeResolution(key probeKey, r resolution) bool {
+	prev, ok := m.resolutions[key]
+	m.resolutions[key] = r
+	return !ok || !reflect.DeepEqual(prev.addresses, r.addresses) || (prev.err == nil) != (r.err == nil)
+}
+
+// addresses returns the addresses the host is probed and published on.
+// The second value is false while a DNS name host has not been resolved yet.
+func (m *proberManager) addresses(key probeKey) ([]string, bool) {
+	if net.ParseIP(key.host) != nil {
+		return []string{key.host}, true
+	}
+	r, ok := m.getResolution(key)
+	if !ok || len(r.addresses) == 0 {
+		return nil, false
+	}
+	return r.addresses, true
+}
+
+// enqueue asks the controller to reconcile the ClusterEndpoint of the key.
+// Returns false if the worker was stopped while waiting.
+func (m *proberManager) enqueue(key probeKey, stopCh <-chan struct{}) bool {
+	cep := &v1beta1.ClusterEndpoint{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	select {
+	case m.updates <- event.GenericEvent{Object: cep}:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
+
+// recordMetrics records a run of a check, the check is empty when the port is probed with its own handler.
+func (m *proberManager) recordMetrics(key probeKey, check string, pt metrics.ProbeType, result probe.Result, err error, duration time.Duration) {
+	if m.metricsInfo == nil {
+		return
+	}
+	instance := key.instance()
+	probeType := string(pt)
+	m.metricsInfo.RecordCheck(key.Name, key.Namespace, instance, probeType, check)
+	m.metricsInfo.RecordCheckDuration(key.Name, key.Namespace, instance, probeType, check, duration.Seconds())
+	if err != nil || result == probe.Failure || result == probe.Unknown {
+		m.metricsInfo.RecordFailedCheck(key.Name, key.Namespace, instance, probeType, check)
+	} else {
+		m.metricsInfo.RecordSuccessfulCheck(key.Name, key.Namespace, instance, probeType, check)
+	}
+}
+
+func (m *proberManager) recordCertificate(key probeKey, info certificateInfo) {
+	if m.metricsInfo == nil {
+		return
+	}
+	m.metricsInfo.RecordCertificateNotAfter(key.Name, key.Namespace, key.instance(), info.issuer, info.notAfter)
+}
+
+// forgetCertificate drops the certificate metric of the address of the key.
+func (m *proberManager) forgetCertificate(key probeKey) {
+	if m.metricsInfo == nil {
+		return
+	}
+	m.metricsInfo.DeleteCertificateNotAfter(key.Name, key.Namespace, key.instance())
+}
+
+// newProbeKey returns the key of a host of the port, with the target port the host overrides.
+func newProbeKey(nn types.NamespacedName, port v1beta1.ServicePort, host string) probeKey {
+	port, host = splitHost(port, host)
+	return probeKey{NamespacedName: nn, portName: port.Name, targetPort: port.TargetPort, host: host, address: host}
+}
+
+func portKeyOf(port v1beta1.ServicePort, check string) portKey {
+	return portKey{name: port.Name, targetPort: port.TargetPort, check: check}
+}
+
+// checkMaterials returns the materials of the checks of the port, by check name.
+func checkMaterials(port v1beta1.ServicePort, materials map[portKey]*portMaterial) map[string]*portMaterial {
+	var result map[string]*portMaterial
+	for _, c := range portChecks(port) {
+		if m, ok := materials[portKeyOf(port, c.Name)]; ok {
+			if result == nil {
+				result = make(map[string]*portMaterial)
+			}
+			result[c.Name] = m
+		}
+	}
+	return result
+}
+
+// needsWorker reports whether the host has to be probed or resolved in the background.
+func needsWorker(port v1beta1.ServicePort, host string) bool {
+	return net.ParseIP(host) == nil || hasChecks(port)
+}
+
+// workerPort strips the hosts off the port, so that a worker is only restarted when its own settings change.
+// The target port is the one of the host of the key.
+func workerPort(port v1beta1.ServicePort, key probeKey) v1beta1.ServicePort {
+	port.TargetPort = key.targetPort
+	port.Hosts = nil
+	port.BackupHosts = nil
+	return port
+}
+
+// portHosts returns the hosts of the port followed by its backup hosts.
+func portHosts(port v1beta1.ServicePort) []string {
+	if len(port.BackupHosts) == 0 {
+		return port.Hosts
+	}
+	hosts := make([]string, 0, len(port.Hosts)+len(port.BackupHosts))
+	return append(append(hosts, port.Hosts...), port.BackupHosts...)
+}
+
+func sortedAddresses(records []dns.Record) []string {
+	seen := make(map[string]bool, len(records))
+	var addresses []string
+	for _, record := range records {
+		address := record.IP.String()
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// newProbe converts the handler of the port into a probe against the host.
+// Returns nil if the port has no probe enabled.
+func newProbe(port v1beta1.ServicePort, host string) *libv1.Probe {
+	pro := newProbeThresholds(port)
+	if port.Exec != nil && len(port.Exec.Command) != 0 {
+		pro.Exec = &libv1.ExecAction{Command: port.Exec.Command}
+	}
+	if port.HTTPGet != nil {
+		pro.HTTPGet = &libv1.HTTPGetAction{
+			Path:        port.HTTPGet.Path,
+			Port:        intstr.FromInt(int(port.TargetPort)),
+			Host:        host,
+			Scheme:      port.HTTPGet.Scheme,
+			HTTPHeaders: port.HTTPGet.HTTPHeaders,
+		}
+	}
+	if port.TCPSocket != nil && port.TCPSocket.Enable {
+		pro.TCPSocket = &libv1.TCPSocketAction{
+			Port: intstr.FromInt(int(port.TargetPort)),
+			Host: host,
+		}
+	}
+	if port.UDPSocket != nil && port.UDPSocket.Enable {
+		pro.UDPSocket = &libv1.UDPSocketAction{
+			Port: intstr.FromInt(int(port.TargetPort)),
+			Host: host,
+			Data: v1beta1.Int8ArrToByteArr(port.UDPSocket.Data),
+		}
+	}
+	if port.GRPC != nil && port.GRPC.Enable {
+		pro.GRPC = &libv1.GRPCAction{
+			Port:    port.TargetPort,
+			Host:    host,
+			Service: port.GRPC.Service,
+		}
+	}
+	if probeTypeOf(port.Handler) == "" {
+		return nil
+	}
+	return pro
+}
+
+// newProbeThresholds returns a probe without handler, with the timeout and thresholds of the port.
+func newProbeThresholds(port v1beta1.ServicePort) *libv1.Probe {
+	if port.TimeoutSeconds == 0 {
+		port.TimeoutSeconds = 1
+	}
+	if port.SuccessThreshold == 0 {
+		port.SuccessThreshold = 1
+	}
+	if port.FailureThreshold == 0 {
+		port.FailureThreshold = 3
+	}
+	return &libv1.Probe{
+		TimeoutSeconds:   port.TimeoutSeconds,
+		SuccessThreshold: port.SuccessThreshold,
+		FailureThreshold: port.FailureThreshold,
+	}
+}
+
+// hasChecks reports whether the hosts of the port are checked at all, hosts without checks are always published.
+func hasChecks(port v1beta1.ServicePort) bool {
+	for _, c := range portChecks(port) {
+		if probeTypeOf(c.Handler) != "" {
+			return true
+		}
+	}
+	return certificateCheckEnabled(port)
+}
+
+// portChecks returns the checks the hosts of the port are probed with.
+// A port without checks is probed with its own handler, as a single unnamed check.
+func portChecks(port v1beta1.ServicePort) []v1beta1.Check {
+	if len(port.Checks) == 0 {
+		return []v1beta1.Check{{Handler: port.Handler}}
+	}
+	return port.Checks
+}
+
+// checkPort returns the port with the handler and the timeout of the check.
+func checkPort(port v1beta1.ServicePort, c v1beta1.Check) v1beta1.ServicePort {
+	port.Handler = c.Handler
+	if c.TimeoutSeconds > 0 {
+		port.TimeoutSeconds = c.TimeoutSeconds
+	}
+	return port
+}
+
+// probeTypeOf returns the type of the handler that is run, the first enabled one wins.
+func probeTypeOf(h v1beta1.Handler) metrics.ProbeType {
+	switch {
+	case h.Exec != nil && len(h.Exec.Command) != 0:
+		return metrics.EXEC
+	case h.HTTPGet != nil:
+		return metrics.HTTP
+	case h.TCPSocket != nil && h.TCPSocket.Enable:
+		return metrics.TCP
+	case h.UDPSocket != nil && h.UDPSocket.Enable:
+		return metrics.UDP
+	case h.GRPC != nil && h.GRPC.Enable:
+		return metrics.GRPC
+	case h.MySQL != nil && h.MySQL.Enable:
+		return metrics.MYSQL
+	case h.PostgreSQL != nil && h.PostgreSQL.Enable:
+		return metrics.POSTGRESQL
+	case h.Redis != nil && h.Redis.Enable:
+		return metrics.REDIS
+	}
+	return ""
+}
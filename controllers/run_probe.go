@@ -18,42 +18,50 @@ limitations under the License.
 package controllers
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/endpoints-operator/utils/metrics"
 	libv1 "github.com/labring/operator-sdk/api/core/v1"
 	"github.com/labring/operator-sdk/probe"
 	execprobe "github.com/labring/operator-sdk/probe/exec"
-	grpcprobe "github.com/labring/operator-sdk/probe/grpc"
-	httpprobe "github.com/labring/operator-sdk/probe/http"
 	tcpprobe "github.com/labring/operator-sdk/probe/tcp"
-	udpprobe "github.com/labring/operator-sdk/probe/udp"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	urutime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/pointer"
 )
 
-type work struct {
-	p          *libv1.Probe
-	resultRun  int
-	lastResult probe.Result
-	retry      int
-	err        error
+// endpoint is the address a probe runs against, with the handler the probe was built from
+// for the settings that libv1.Probe has no room for.
+type endpoint struct {
+	host    string
+	port    int32
+	handler *v1beta1.Handler
+	// tls is the client config of HTTPS, gRPC and PostgreSQL probes.
+	tls *tls.Config
+	// password is sent by Redis probes.
+	password []byte
+	// err is why the Secrets and ConfigMaps of the endpoint could not be loaded or used.
+	err error
 }
 
-func (pb *prober) runProbeWithRetries(p *libv1.Probe, retries int) (probe.Result, string, error) {
+func (pb *prober) runProbeWithRetries(p *libv1.Probe, ep endpoint, retries int) (probe.Result, string, error) {
 	var err error
 	var result probe.Result
 	var output string
 	for i := 0; i < retries; i++ {
-		result, output, err = pb.runProbe(p)
+		result, output, err = pb.runProbe(p, ep)
 		if err == nil {
 			return result, output, nil
 		}
@@ -61,47 +69,14 @@ func (pb *prober) runProbeWithRetries(p *libv1.Probe, retries int) (probe.Result
 	return result, output, err
 }
 
-// nolint: errcheck
-func (w *work) doProbe() (keepGoing bool) {
-	defer func() { recover() }() // Actually eat panics (HandleCrash takes care of logging)
-	defer urutime.HandleCrash(func(_ interface{}) { keepGoing = true })
-
-	// the full container environment here, OR we must make a call to the CRI in order to get those environment
-	// values from the running container.
-	result, output, err := proberCheck.runProbeWithRetries(w.p, w.retry)
-	if err != nil {
-		w.err = err
-		return false
-	}
-
-	if w.lastResult == result {
-		w.resultRun++
-	} else {
-		w.lastResult = result
-		w.resultRun = 1
-	}
-
-	if (result == probe.Failure && w.resultRun < int(w.p.FailureThreshold)) ||
-		(result == probe.Success && w.resultRun < int(w.p.SuccessThreshold)) {
-		// Success or failure is below threshold - leave the probe state unchanged.
-		return true
-	}
-
-	if err != nil {
-		w.err = err
-	} else if result == probe.Failure && len(output) != 0 {
-		w.err = errors.New(output)
-	}
-	return false
-}
-
 // Prober helps to check the liveness/readiness/startup of a container.
 type prober struct {
+	runner utilexec.Interface
+
 	exec execprobe.Prober
-	http httpprobe.Prober
+	http *httpProber
 	tcp  tcpprobe.Prober
-	udp  udpprobe.Prober
-	grpc grpcprobe.Prober
+	grpc grpcProber
 }
 
 var proberCheck = newProber()
@@ -109,23 +84,33 @@ var proberCheck = newProber()
 // NewProber creates a Prober, it takes a command runner and
 // several container info managers.
 func newProber() *prober {
-
-	const followNonLocalRedirects = false
 	return &prober{
-		exec: execprobe.New(),
-		http: httpprobe.New(followNonLocalRedirects),
-		tcp:  tcpprobe.New(),
-		udp:  udpprobe.New(),
-		grpc: grpcprobe.New(),
+		runner: utilexec.New(),
+		exec:   execprobe.New(),
+		http:   newHTTPProber(),
+		tcp:    tcpprobe.New(),
+		grpc:   grpcProber{},
 	}
 }
 
-func (pb *prober) runProbe(p *libv1.Probe) (probe.Result, string, error) {
+// runProbe runs the handler of the probe once against the endpoint.
+func (pb *prober) runProbe(p *libv1.Probe, ep endpoint) (probe.Result, string, error) {
 	timeout := time.Duration(p.TimeoutSeconds) * time.Second
 	if p.Exec != nil {
-		klog.V(4).Infof("Exec-Probe Command: %v", p.Exec.Command)
-		//command := ""
-		return probe.Success, "", nil
+		if ep.err != nil {
+			return probe.Unknown, "", ep.err
+		}
+		env := execEnv(ep.host, ep.port)
+		klog.V(4).Infof("Exec-Probe Command: %v, Env: %v", p.Exec.Command, env)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := pb.runner.CommandContext(ctx, p.Exec.Command[0], p.Exec.Command[1:]...)
+		cmd.SetEnv(append(os.Environ(), env...))
+		result, output, err := pb.exec.Probe(cmd)
+		if ctx.Err() == context.DeadlineExceeded {
+			return probe.Failure, fmt.Sprintf("command timed out after %v", timeout), nil
+		}
+		return result, output, err
 	}
 	if p.HTTPGet != nil {
 		scheme := strings.ToLower(string(p.HTTPGet.Scheme))
@@ -137,9 +122,15 @@ func (pb *prober) runProbe(p *libv1.Probe) (probe.Result, string, error) {
 		path := p.HTTPGet.Path
 		klog.V(4).Infof("HTTP-Probe Host: %v://%v, Port: %v, Path: %v", scheme, host, port, path)
 		url := formatURL(scheme, host, port, path)
-		headers := buildHeader(p.HTTPGet.HTTPHeaders)
-		klog.V(4).Infof("HTTP-Probe Headers: %v", headers)
-		return pb.http.Probe(url, headers, timeout)
+		action := &v1beta1.HTTPGetAction{HTTPHeaders: p.HTTPGet.HTTPHeaders}
+		if ep.handler != nil && ep.handler.HTTPGet != nil {
+			action = ep.handler.HTTPGet
+		}
+		if ep.err != nil {
+			return probe.Unknown, "", ep.err
+		}
+		klog.V(4).Infof("HTTP-Probe Method: %v, Headers: %v", action.Method, action.HTTPHeaders)
+		return pb.http.Probe(url, action, ep.tls, timeout)
 	}
 	if p.TCPSocket != nil {
 		port, err := extractPort(p.TCPSocket.Port)
@@ -148,6 +139,9 @@ func (pb *prober) runProbe(p *libv1.Probe) (probe.Result, string, error) {
 		}
 		host := p.TCPSocket.Host
 		klog.V(4).Infof("TCP-Probe Host: %v, Port: %v, Timeout: %v", host, port, timeout)
+		if ep.handler != nil && ep.handler.TCPSocket != nil && tcpCheckEnabled(ep.handler.TCPSocket) {
+			return tcpCheck(host, port, ep.handler.TCPSocket, timeout)
+		}
 		return pb.tcp.Probe(host, port, timeout)
 	}
 	if p.UDPSocket != nil {
@@ -157,13 +151,37 @@ func (pb *prober) runProbe(p *libv1.Probe) (probe.Result, string, error) {
 		}
 		host := p.UDPSocket.Host
 		klog.V(4).Infof("UDP-Probe Host: %v, Port: %v, Timeout: %v", host, port, timeout)
-		return pb.udp.Probe(host, port, p.UDPSocket.Data, timeout)
+		action := &v1beta1.UDPSocketAction{Data: p.UDPSocket.Data}
+		if ep.handler != nil && ep.handler.UDPSocket != nil {
+			action = ep.handler.UDPSocket
+		}
+		return udpCheck(host, port, action, timeout)
 	}
 	if p.GRPC != nil {
-		host := &(p.GRPC.Host)
-		service := p.GRPC.Service
-		klog.V(4).Info("GRPC-Probe Host: %v,Service: %v, Port: %v, Timeout: %v", host, service, p.GRPC.Port, timeout)
-		return pb.grpc.Probe(*host, *service, int(p.GRPC.Port), timeout)
+		if ep.err != nil {
+			return probe.Unknown, "", ep.err
+		}
+		host := p.GRPC.Host
+		service := pointer.StringDeref(p.GRPC.Service, "")
+		klog.V(4).Infof("GRPC-Probe Host: %v, Service: %v, Port: %v, TLS: %v, Timeout: %v", host, service, p.GRPC.Port, ep.tls != nil, timeout)
+		return pb.grpc.Probe(host, service, int(p.GRPC.Port), ep.tls, timeout)
+	}
+	if h := ep.handler; h != nil {
+		if ep.err != nil && probeTypeOf(*h) != "" {
+			return probe.Unknown, "", ep.err
+		}
+		port := int(ep.port)
+		switch probeTypeOf(*h) {
+		case metrics.MYSQL:
+			klog.V(4).Infof("MySQL-Probe Host: %v, Port: %v, Timeout: %v", ep.host, port, timeout)
+			return mysqlProbe(ep.host, port, timeout)
+		case metrics.POSTGRESQL:
+			klog.V(4).Infof("PostgreSQL-Probe Host: %v, Port: %v, Timeout: %v", ep.host, port, timeout)
+			return postgresProbe(ep.host, port, ep.tls, timeout)
+		case metrics.REDIS:
+			klog.V(4).Infof("Redis-Probe Host: %v, Port: %v, Auth: %v, Timeout: %v", ep.host, port, len(ep.password) != 0, timeout)
+			return redisProbe(ep.host, port, h.Redis.Username, ep.password, timeout)
+		}
 	}
 	klog.Warning("failed to find probe builder")
 	return probe.Warning, "", nil
@@ -197,6 +215,11 @@ func formatURL(scheme string, host string, port int, path string) *url.URL {
 	return u
 }
 
+// execEnv returns the environment that tells an exec probe which host to check.
+func execEnv(host string, port int32) []string {
+	return []string{"HOST=" + host, "PORT=" + strconv.Itoa(int(port))}
+}
+
 // buildHeaderMap takes a list of HTTPHeader <name, value> string
 // pairs and returns a populated string->[]string http.Header map.
 func buildHeader(headerList []v1.HTTPHeader) http.Header {
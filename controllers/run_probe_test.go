@@ -0,0 +1,73 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRunExecProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		want    probe.Result
+	}{
+		{name: "env", command: []string{"sh", "-c", `test "$HOST:$PORT" = "10.0.0.1:5432"`}, want: probe.Success},
+		{name: "exit code", command: []string{"sh", "-c", "exit 2"}, want: probe.Failure},
+		{name: "timeout", command: []string{"sleep", "5"}, want: probe.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := v1beta1.ServicePort{
+				Handler:    v1beta1.Handler{Exec: &v1beta1.ExecAction{Command: tt.command}},
+				TargetPort: 5432,
+			}
+			got, output, err := proberCheck.runProbe(newProbe(port, "10.0.0.1"), endpoint{host: "10.0.0.1", port: 5432, handler: &port.Handler})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("runProbe() got = %v (%q), want %v", got, output, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecProbeDisabled(t *testing.T) {
+	port := v1beta1.ServicePort{
+		Hosts:            []string{"10.0.0.1"},
+		Handler:          v1beta1.Handler{Exec: &v1beta1.ExecAction{Command: []string{"true"}}},
+		FailureThreshold: 1,
+		Name:             "default",
+		TargetPort:       5432,
+	}
+	for _, enabled := range []bool{false, true} {
+		m := newProberManager(1, nil)
+		m.execEnabled = enabled
+		key := newProbeKey(types.NamespacedName{Namespace: "default", Name: "cep"}, port, "10.0.0.1")
+		w := newWorker(m, key, workerPort(port, key), nil, time.Second)
+		w.doProbe()
+		if r, _ := m.getResult(key); r.ready != enabled {
+			t.Errorf("exec enabled %v: expected ready %v, got %+v", enabled, enabled, r)
+		}
+	}
+}
@@ -18,18 +18,22 @@ package controllers
 
 import (
 	"context"
-	"github.com/labring/endpoints-operator/utils/metrics"
+	"encoding/json"
+	"fmt"
+	"net"
 	"strconv"
-	"sync"
-
-	"k8s.io/klog"
+	"time"
 
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
-	libv1 "github.com/labring/operator-sdk/api/core/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/client-go/util/retry"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -43,26 +47,7 @@ func (c *Reconciler) syncService(ctx context.Context, cep *v1beta1.ClusterEndpoi
 		Message:            "sync service successfully",
 	}
 
-	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		svc := &corev1.Service{}
-		svc.SetName(cep.Name)
-		svc.SetNamespace(cep.Namespace)
-		_, err := controllerutil.CreateOrUpdate(ctx, c.Client, svc, func() error {
-			svc.Labels = cep.Labels
-			svc.Annotations = cep.Annotations
-			if err := controllerutil.SetControllerReference(cep, svc, c.scheme); err != nil {
-				return err
-			}
-			if cep.Spec.ClusterIP == corev1.ClusterIPNone {
-				svc.Spec.ClusterIP = corev1.ClusterIPNone
-			}
-			svc.Spec.Type = corev1.ServiceTypeClusterIP
-			svc.Spec.SessionAffinity = corev1.ServiceAffinityNone
-			svc.Spec.Ports = convertServicePorts(cep.Spec.Ports)
-			return nil
-		})
-		return err
-	}); err != nil {
+	if err := c.applyService(ctx, cep); err != nil {
 		serviceCondition.LastHeartbeatTime = metav1.Now()
 		serviceCondition.Status = corev1.ConditionFalse
 		serviceCondition.Reason = "ServiceSyncError"
@@ -75,7 +60,82 @@ func (c *Reconciler) syncService(ctx context.Context, cep *v1beta1.ClusterEndpoi
 		c.updateCondition(cep, serviceCondition)
 	}
 }
-func (c *Reconciler) syncEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpoint) {
+
+// syncPaused reports whether the ClusterEndpoint is paused, the Service and the Endpoints are left alone while it is.
+func (c *Reconciler) syncPaused(cep *v1beta1.ClusterEndpoint) bool {
+	if !cep.Spec.Paused && cep.Annotations[v1beta1.PausedAnnotation] != "true" {
+		removeCondition(cep, v1beta1.Paused)
+		return false
+	}
+	if !isConditionTrue(cep, v1beta1.Paused) {
+		c.updateCondition(cep, v1beta1.Condition{
+			Type:               v1beta1.Paused,
+			Status:             corev1.ConditionTrue,
+			LastHeartbeatTime:  metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+			Reason:             string(v1beta1.Paused),
+			Message:            "sync of service and endpoint is paused",
+		})
+	}
+	return true
+}
+
+// syncHostsResolved reports the lookup errors of the DNS name hosts.
+func (c *Reconciler) syncHostsResolved(cep *v1beta1.ClusterEndpoint) {
+	resolvedCondition := v1beta1.Condition{
+		Type:               v1beta1.HostsResolved,
+		Status:             corev1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             string(v1beta1.HostsResolved),
+		Message:            "resolve hosts successfully",
+	}
+	resolveErrors, hasNames := clusterEndpointResolveErrors(cep, c.prober)
+	if !hasNames {
+		removeCondition(cep, v1beta1.HostsResolved)
+		return
+	}
+	if len(resolveErrors) != 0 {
+		err := ToAggregate(resolveErrors)
+		resolvedCondition.Status = corev1.ConditionFalse
+		resolvedCondition.Reason = "HostsResolveError"
+		resolvedCondition.Message = err.Error()
+		c.updateCondition(cep, resolvedCondition)
+		c.logger.V(4).Info("error resolving hosts", "name", cep.Name, "msg", err.Error())
+		return
+	}
+	if !isConditionTrue(cep, v1beta1.HostsResolved) {
+		c.updateCondition(cep, resolvedCondition)
+	}
+}
+
+// syncDegraded checks the healthy addresses against the panic threshold, and returns the subsets to publish.
+func (c *Reconciler) syncDegraded(cep *v1beta1.ClusterEndpoint, subsets, published []corev1.EndpointSubset) []corev1.EndpointSubset {
+	healthy, total := clusterEndpointHealthyAddresses(cep, c.prober, subsets)
+	if cep.Spec.MinHealthyPercent <= 0 || total == 0 || healthy*100 >= total*int(cep.Spec.MinHealthyPercent) {
+		removeCondition(cep, v1beta1.Degraded)
+		return subsets
+	}
+	degradedCondition := v1beta1.Condition{
+		Type:               v1beta1.Degraded,
+		Status:             corev1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "BelowMinHealthyPercent",
+		Message:            fmt.Sprintf("%d of %d addresses are healthy, below %d%%, publishing with policy %s", healthy, total, cep.Spec.MinHealthyPercent, panicPolicy(cep)),
+	}
+	if !isConditionTrue(cep, v1beta1.Degraded) {
+		c.recorder.Eventf(cep, corev1.EventTypeWarning, "Degraded", "Only %d of %d addresses are healthy, ignoring the probes and publishing with policy %s", healthy, total, panicPolicy(cep))
+	}
+	c.updateCondition(cep, degradedCondition)
+	c.logger.V(4).Info("healthy addresses below threshold", "name", cep.Name, "healthy", healthy, "total", total)
+	if panicPolicy(cep) == v1beta1.PanicPolicyLastKnownGood && len(published) != 0 {
+		return published
+	}
+	return clusterEndpointAllEndpointSubset(cep, c.prober)
+}
+
+func (c *Reconciler) syncEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpoint, subsets []corev1.EndpointSubset, syncError error) {
 	endpointCondition := v1beta1.Condition{
 		Type:               v1beta1.SyncEndpointReady,
 		Status:             corev1.ConditionTrue,
@@ -84,28 +144,15 @@ func (c *Reconciler) syncEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpo
 		Reason:             string(v1beta1.SyncEndpointReady),
 		Message:            "sync endpoint successfully",
 	}
-	var syncError error = nil
-	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-
-		subsets, convertError := clusterEndpointConvertEndpointSubset(cep, c.RetryCount, c.MetricsInfo)
-
-		if convertError != nil && len(convertError) != 0 {
-			syncError = ToAggregate(convertError)
-		}
-		ep := &corev1.Endpoints{}
-		ep.SetName(cep.Name)
-		ep.SetNamespace(cep.Namespace)
-
-		_, err := controllerutil.CreateOrUpdate(ctx, c.Client, ep, func() error {
-			ep.Labels = map[string]string{}
-			if err := controllerutil.SetControllerReference(cep, ep, c.scheme); err != nil {
-				return err
-			}
-			ep.Subsets = subsets
-			return nil
-		})
-		return err
-	}); err != nil {
+	mode := endpointMode(cep)
+	var err error
+	if mode == v1beta1.EndpointModeEndpointSlice {
+		// Only the EndpointSlices are published, drop the Endpoints we wrote before.
+		err = c.deleteEndpoint(ctx, cep)
+	} else {
+		err = c.updateEndpoint(ctx, cep, subsets, mode)
+	}
+	if err != nil {
 		endpointCondition.LastHeartbeatTime = metav1.Now()
 		endpointCondition.Status = corev1.ConditionFalse
 		endpointCondition.Reason = "EndpointSyncError"
@@ -128,132 +175,662 @@ func (c *Reconciler) syncEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpo
 	}
 }
 
-func clusterEndpointConvertEndpointSubset(cep *v1beta1.ClusterEndpoint, retry int, metricsinfo *metrics.MetricsInfo) ([]corev1.EndpointSubset, []error) {
-	var wg sync.WaitGroup
-	var mx sync.Mutex
+// applyService writes the fields of the Service the ClusterEndpoint owns with server-side apply,
+// the fields of other managers are kept.
+func (c *Reconciler) applyService(ctx context.Context, cep *v1beta1.ClusterEndpoint) error {
+	if err := c.checkAdoption(ctx, cep, &corev1.Service{}, "service"); err != nil {
+		return err
+	}
+	svc := &corev1.Service{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}}
+	svc.SetName(cep.Name)
+	svc.SetNamespace(cep.Namespace)
+	svc.Spec.Ports = convertServicePorts(cep.Spec.Ports)
+	current := &corev1.Service{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(cep), current)
+	if err != nil && client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	exists := err == nil
+	if exists {
+		if err := c.upgradeManagedFields(ctx, current); err != nil {
+			return err
+		}
+	}
+	if adoptionPolicy(cep) != v1beta1.AdoptionPolicyMerge {
+		svc.Labels = cep.Labels
+		svc.Annotations = cep.Annotations
+		if err := controllerutil.SetControllerReference(cep, svc, c.scheme); err != nil {
+			return err
+		}
+		setServiceSpec(&svc.Spec, cep.Spec, clusterEndpointIPFamilies(cep), current.Spec.IPFamilies)
+	}
+	if exists && serviceUpToDate(current, svc) {
+		return nil
+	}
+	return c.Patch(ctx, svc, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// upgradeManagedFields hands the fields written with Update by the operator before it used server-side apply
+// over to the apply manager, so that fields we stop applying are removed instead of staying owned by the old entry.
+// Nothing is written when the object has no such entry.
+func (c *Reconciler) upgradeManagedFields(ctx context.Context, obj client.Object) error {
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(obj, sets.New(fieldManager), fieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	return c.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// serviceUpToDate reports whether the fields we applied to the Service last time are the ones we would apply now.
+// Fields of the Service we apply are compared to what the managed fields say we own, so that fields
+// defaulted or written by others do not count as changes.
+func serviceUpToDate(current, desired *corev1.Service) bool {
+	applied, err := corev1ac.ExtractService(current, fieldManager)
+	if err != nil {
+		return false
+	}
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return false
+	}
+	want := &corev1ac.ServiceApplyConfiguration{}
+	if err := json.Unmarshal(data, want); err != nil {
+		return false
+	}
+	want.Status = nil
+	return equality.Semantic.DeepEqual(applied, want)
+}
+
+// setServiceSpec copies the Service-level settings of the ClusterEndpoint. Fields that are only
+// valid for NodePort and LoadBalancer Services are dropped for ClusterIP Services, the API server rejects them.
+// The ipFamilies and ipFamilyPolicy of the spec are copied as they are. Without them the API server picks the family
+// of a new Service, and an existing Service keeps its primary family: the API server rejects a change of it, and
+// a family that is not configured in the cluster. The family of IP address hosts that is not the primary one is
+// only appended, as PreferDualStack, which a single-stack cluster accepts.
+func setServiceSpec(spec *corev1.ServiceSpec, cepSpec v1beta1.ClusterEndpointSpec, hostFamilies, currentFamilies []corev1.IPFamily) {
+	spec.ClusterIP = cepSpec.ClusterIP
+	spec.ClusterIPs = cepSpec.ClusterIPs
+	spec.Type = cepSpec.Type
+	if spec.Type == "" {
+		spec.Type = corev1.ServiceTypeClusterIP
+	}
+	spec.SessionAffinity = cepSpec.SessionAffinity
+	if spec.SessionAffinity == "" {
+		spec.SessionAffinity = corev1.ServiceAffinityNone
+	}
+	if spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		spec.SessionAffinityConfig = cepSpec.SessionAffinityConfig
+	}
+	spec.IPFamilies = cepSpec.IPFamilies
+	spec.IPFamilyPolicy = cepSpec.IPFamilyPolicy
+	if len(spec.IPFamilies) == 0 && spec.IPFamilyPolicy == nil && len(currentFamilies) != 0 {
+		for _, family := range hostFamilies {
+			if family != currentFamilies[0] {
+				policy := corev1.IPFamilyPolicyPreferDualStack
+				spec.IPFamilies = []corev1.IPFamily{currentFamilies[0], family}
+				spec.IPFamilyPolicy = &policy
+				break
+			}
+		}
+	}
+	spec.InternalTrafficPolicy = cepSpec.InternalTrafficPolicy
+	if spec.Type == corev1.ServiceTypeClusterIP {
+		for i := range spec.Ports {
+			spec.Ports[i].NodePort = 0
+		}
+		return
+	}
+	spec.ExternalTrafficPolicy = cepSpec.ExternalTrafficPolicy
+	if spec.Type == corev1.ServiceTypeLoadBalancer {
+		spec.LoadBalancerSourceRanges = cepSpec.LoadBalancerSourceRanges
+	}
+}
+
+// updateEndpoint writes the subsets of the Endpoints with server-side apply, the fields of other managers are kept.
+// Nothing is written when the Endpoints already have the subsets.
+func (c *Reconciler) updateEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpoint, subsets []corev1.EndpointSubset, mode v1beta1.EndpointMode) error {
+	if err := c.checkAdoption(ctx, cep, &corev1.Endpoints{}, "endpoints"); err != nil {
+		return err
+	}
+	current := &corev1.Endpoints{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cep), current); err == nil {
+		if err := c.upgradeManagedFields(ctx, current); err != nil {
+			return err
+		}
+		if endpointsUpToDate(cep, current, subsets, mode) {
+			return nil
+		}
+	}
+	ep := &corev1.Endpoints{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Endpoints"}}
+	ep.SetName(cep.Name)
+	ep.SetNamespace(cep.Namespace)
+	if mode == v1beta1.EndpointModeBoth {
+		// The EndpointSlices are written by us, keep the mirroring controller away.
+		ep.Labels = map[string]string{discoveryv1.LabelSkipMirror: "true"}
+	}
+	if adoptionPolicy(cep) != v1beta1.AdoptionPolicyMerge {
+		if err := controllerutil.SetControllerReference(cep, ep, c.scheme); err != nil {
+			return err
+		}
+	}
+	ep.Subsets = subsets
+	return c.Patch(ctx, ep, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// endpointsUpToDate reports whether applying the subsets would leave the Endpoints unchanged.
+func endpointsUpToDate(cep *v1beta1.ClusterEndpoint, ep *corev1.Endpoints, subsets []corev1.EndpointSubset, mode v1beta1.EndpointMode) bool {
+	if (ep.Labels[discoveryv1.LabelSkipMirror] == "true") != (mode == v1beta1.EndpointModeBoth) {
+		return false
+	}
+	if adoptionPolicy(cep) != v1beta1.AdoptionPolicyMerge && !metav1.IsControlledBy(ep, cep) {
+		return false
+	}
+	return equality.Semantic.DeepEqual(repackSubsets(ep.Subsets), subsets)
+}
+
+// checkAdoption fails when the object exists without being controlled by the ClusterEndpoint,
+// and the adoption policy forbids taking it over.
+func (c *Reconciler) checkAdoption(ctx context.Context, cep *v1beta1.ClusterEndpoint, obj client.Object, kind string) error {
+	if adoptionPolicy(cep) != v1beta1.AdoptionPolicyFailIfExists {
+		return nil
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cep), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, cep) {
+		return fmt.Errorf("%s %s already exists and is not controlled by the ClusterEndpoint", kind, cep.Name)
+	}
+	return nil
+}
+
+func adoptionPolicy(cep *v1beta1.ClusterEndpoint) v1beta1.AdoptionPolicy {
+	if cep.Spec.AdoptionPolicy == "" {
+		return v1beta1.AdoptionPolicyAdopt
+	}
+	return cep.Spec.AdoptionPolicy
+}
+
+// convertEndpointSubset builds the subsets from the cached probe results and records the health of the hosts in the status.
+// Below the panic threshold the hosts are published regardless of their health, the status still reports it.
+func (c *Reconciler) convertEndpointSubset(ctx context.Context, cep *v1beta1.ClusterEndpoint, paused bool) ([]corev1.EndpointSubset, error) {
+	published := c.publishedEndpointSubset(ctx, cep)
+	subsets, convertError := clusterEndpointConvertEndpointSubset(cep, c.prober, published)
+	subsets = repackSubsets(c.syncDegraded(cep, subsets, published))
+	// The hosts are ready when they are published: in the subsets about to be written, or while paused
+	// in the ones that stay in place.
+	ready := subsets
+	if paused {
+		ready = published
+	}
+	hosts := clusterEndpointHostStatus(cep, c.prober, ready)
+	c.recordExpiringCertificates(cep, hosts)
+	c.recordFailovers(cep, hosts)
+	cep.Status.Hosts = hosts
+	if len(convertError) != 0 {
+		return subsets, ToAggregate(convertError)
+	}
+	return subsets, nil
+}
+
+// publishedEndpointSubset returns the subsets currently published, from the Endpoints or else from the EndpointSlices.
+func (c *Reconciler) publishedEndpointSubset(ctx context.Context, cep *v1beta1.ClusterEndpoint) []corev1.EndpointSubset {
+	ep := &corev1.Endpoints{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cep), ep); err == nil && len(ep.Subsets) != 0 {
+		return ep.Subsets
+	}
+	slices, err := c.listEndpointSlices(ctx, cep)
+	if err != nil {
+		return nil
+	}
+	return endpointSlicesToSubsets(slices)
+}
+
+func (c *Reconciler) deleteEndpoint(ctx context.Context, cep *v1beta1.ClusterEndpoint) error {
+	ep := &corev1.Endpoints{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(cep), ep); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(ep, cep) {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, ep))
+}
+
+// orphanResources removes the owner references to the ClusterEndpoint from the Service, the Endpoints
+// and the EndpointSlices, so that they are not garbage collected along with it.
+func (c *Reconciler) orphanResources(ctx context.Context, cep *v1beta1.ClusterEndpoint) error {
+	var errs []error
+	for _, obj := range []client.Object{&corev1.Service{}, &corev1.Endpoints{}} {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(cep), obj); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := c.removeOwnerReference(ctx, cep, obj); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	slices, err := c.listEndpointSlices(ctx, cep)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i := range slices {
+		if err := c.removeOwnerReference(ctx, cep, &slices[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return ToAggregate(errs)
+	}
+	return nil
+}
+
+func (c *Reconciler) removeOwnerReference(ctx context.Context, cep *v1beta1.ClusterEndpoint, obj client.Object) error {
+	var refs []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != cep.UID {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == len(obj.GetOwnerReferences()) {
+		return nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	obj.SetOwnerReferences(refs)
+	return client.IgnoreNotFound(c.Patch(ctx, obj, patch))
+}
+
+func endpointMode(cep *v1beta1.ClusterEndpoint) v1beta1.EndpointMode {
+	if cep.Spec.EndpointMode == "" {
+		return v1beta1.EndpointModeEndpoints
+	}
+	return cep.Spec.EndpointMode
+}
+
+// clusterEndpointConvertEndpointSubset builds the subsets from the cached probe results.
+// Hosts that have not been probed or resolved yet keep whatever state is currently published.
+// The backup hosts of a port are only published while none of its hosts is healthy.
+func clusterEndpointConvertEndpointSubset(cep *v1beta1.ClusterEndpoint, results *proberManager, published []corev1.EndpointSubset) ([]corev1.EndpointSubset, []error) {
 	var data []corev1.EndpointSubset
 	var errors []error
-	var pointList []metrics.Point
-
-	for _, p := range cep.Spec.Ports {
-		for _, h := range p.Hosts {
-			wg.Add(1)
-			go func(port v1beta1.ServicePort, host string) {
-				defer wg.Done()
-				if port.TimeoutSeconds == 0 {
-					port.TimeoutSeconds = 1
-				}
-				if port.SuccessThreshold == 0 {
-					port.SuccessThreshold = 1
+	nn := client.ObjectKeyFromObject(cep)
+
+	for _, port := range cep.Spec.Ports {
+		// Addresses of the port that belong to a known host as address:port, an address is only published once.
+		claimed := sets.NewString()
+		for _, host := range portHosts(port) {
+			key := newProbeKey(nn, port, host)
+			addresses, _ := results.addresses(key)
+			for _, address := range addresses {
+				claimed.Insert(key.withAddress(address).instance())
+			}
+		}
+		primary, healthy, primaryErrors := convertHostsEndpointSubset(cep, results, published, port, port.Hosts, claimed)
+		errors = append(errors, primaryErrors...)
+		if len(port.BackupHosts) == 0 {
+			data = append(data, primary...)
+			continue
+		}
+		backup, backupHealthy, backupErrors := convertHostsEndpointSubset(cep, results, published, port, port.BackupHosts, claimed)
+		errors = append(errors, backupErrors...)
+		if !healthy && backupHealthy {
+			data = append(data, backup...)
+		} else {
+			data = append(data, primary...)
+		}
+	}
+	return data, errors
+}
+
+// convertHostsEndpointSubset builds the subsets of some hosts of the port, and reports whether one of them is healthy.
+func convertHostsEndpointSubset(cep *v1beta1.ClusterEndpoint, results *proberManager, published []corev1.EndpointSubset, port v1beta1.ServicePort, hosts []string, claimed sets.String) ([]corev1.EndpointSubset, bool, []error) {
+	var data []corev1.EndpointSubset
+	var errors []error
+	nn := client.ObjectKeyFromObject(cep)
+	publishNotReady := cep.Spec.NotReadyPolicy == v1beta1.NotReadyPolicyNotReadyAddresses
+	drained := drainedHosts(cep)
+	healthy := false
+
+	// Addresses as address:port, hosts with their own target port are published with it.
+	seen := sets.NewString()
+	var unresolved []v1beta1.ServicePort
+	for _, host := range hosts {
+		if drained.Has(host) {
+			continue
+		}
+		if err := validateHost(host); err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		hostPort, _ := splitHost(port, host)
+		key := newProbeKey(nn, port, host)
+		addresses, ok := results.addresses(key)
+		if !ok {
+			unresolved = append(unresolved, hostPort)
+		}
+		for _, address := range addresses {
+			addressKey := key.withAddress(address)
+			if seen.Has(addressKey.instance()) || isDrained(drained, host, addressKey) {
+				continue
+			}
+			if err := servesAddress(cep, address); err != nil {
+				// Addresses of the other families a name resolves to are expected, addresses given as hosts are not.
+				if address == key.host {
+					errors = append(errors, err)
 				}
-				if port.FailureThreshold == 0 {
-					port.FailureThreshold = 3
+				continue
+			}
+			seen.Insert(addressKey.instance())
+			if !hasChecks(port) {
+				data = append(data, hostPort.ToEndpointSubset(address))
+				healthy = true
+				continue
+			}
+			result, _ := results.getResult(addressKey)
+			switch {
+			case result.initialized && result.ready:
+				data = append(data, hostPort.ToEndpointSubset(address))
+				healthy = true
+			case !result.initialized && isEndpointPublished(published, hostPort, address):
+				data = append(data, hostPort.ToEndpointSubset(address))
+				healthy = true
+			case publishNotReady:
+				data = append(data, hostPort.ToNotReadyEndpointSubset(address))
+			}
+			if result.initialized && !result.ready {
+				errors = append(errors, unhealthyError(addressKey))
+			}
+		}
+	}
+	// Keep what the unresolved DNS names published before, until their lookup succeeds.
+	for _, hostPort := range unresolved {
+		for _, address := range publishedAddresses(published, hostPort) {
+			instance := net.JoinHostPort(address, strconv.Itoa(int(hostPort.TargetPort)))
+			if !claimed.Has(instance) && !seen.Has(instance) && !drained.Has(address) && !drained.Has(instance) && servesAddress(cep, address) == nil {
+				seen.Insert(instance)
+				data = append(data, hostPort.ToEndpointSubset(address))
+				healthy = true
+			}
+		}
+	}
+	return data, healthy, errors
+}
+
+// unhealthyError is what the SyncEndpointReady condition reports for an address that fails its checks.
+// The probe error is only in the status of the host: it carries the local port of the connection,
+// the condition would change on every probe.
+func unhealthyError(key probeKey) error {
+	return fmt.Errorf("%s of port %s is unhealthy", key.instance(), key.portName)
+}
+
+// clusterEndpointHealthyAddresses counts the ready addresses of the subsets, and the addresses of the hosts
+// that are not drained. Backup hosts only count as healthy, up to the number of hosts they stand in for.
+func clusterEndpointHealthyAddresses(cep *v1beta1.ClusterEndpoint, results *proberManager, subsets []corev1.EndpointSubset) (healthy, total int) {
+	nn := client.ObjectKeyFromObject(cep)
+	drained := drainedHosts(cep)
+	for _, port := range cep.Spec.Ports {
+		// Addresses as address:port.
+		addresses := sets.NewString()
+		for _, host := range port.Hosts {
+			if drained.Has(host) {
+				continue
+			}
+			key := newProbeKey(nn, port, host)
+			resolved, _ := results.addresses(key)
+			for _, address := range resolved {
+				if !isDrained(drained, host, key.withAddress(address)) && servesAddress(cep, address) == nil {
+					addresses.Insert(key.withAddress(address).instance())
 				}
-				pro := &libv1.Probe{
-					TimeoutSeconds:   port.TimeoutSeconds,
-					SuccessThreshold: port.SuccessThreshold,
-					FailureThreshold: port.FailureThreshold,
+			}
+		}
+		published := sets.NewString()
+		targetPorts := make(map[int32]bool)
+		for _, host := range portHosts(port) {
+			hostPort, _ := splitHost(port, host)
+			if targetPorts[hostPort.TargetPort] {
+				continue
+			}
+			targetPorts[hostPort.TargetPort] = true
+			for _, address := range publishedAddresses(subsets, hostPort) {
+				published.Insert(net.JoinHostPort(address, strconv.Itoa(int(hostPort.TargetPort))))
+			}
+		}
+		ready := published.Len()
+		if ready > addresses.Len() {
+			ready = addresses.Len()
+		}
+		healthy += ready
+		total += addresses.Len()
+	}
+	return healthy, total
+}
+
+// clusterEndpointAllEndpointSubset publishes every resolved address of the hosts that are not drained as ready.
+func clusterEndpointAllEndpointSubset(cep *v1beta1.ClusterEndpoint, results *proberManager) []corev1.EndpointSubset {
+	var data []corev1.EndpointSubset
+	nn := client.ObjectKeyFromObject(cep)
+	drained := drainedHosts(cep)
+	for _, port := range cep.Spec.Ports {
+		seen := sets.NewString()
+		for _, host := range port.Hosts {
+			if drained.Has(host) {
+				continue
+			}
+			hostPort, _ := splitHost(port, host)
+			key := newProbeKey(nn, port, host)
+			addresses, _ := results.addresses(key)
+			for _, address := range addresses {
+				addressKey := key.withAddress(address)
+				if !seen.Has(addressKey.instance()) && !isDrained(drained, host, addressKey) && servesAddress(cep, address) == nil {
+					seen.Insert(addressKey.instance())
+					data = append(data, hostPort.ToEndpointSubset(address))
 				}
-				if port.HTTPGet != nil {
-					// add metrics point
-					pointList = append(pointList, metrics.Point{
-						Name:              cep.Name,
-						Namespace:         cep.Namespace,
-						TargetHostAndPort: host + ":" + strconv.Itoa(int(port.TargetPort)),
-						ProbeType:         metrics.HTTP,
-					})
-					pro.HTTPGet = &libv1.HTTPGetAction{
-						Path:        port.HTTPGet.Path,
-						Port:        intstr.FromInt(int(port.TargetPort)),
-						Host:        host,
-						Scheme:      port.HTTPGet.Scheme,
-						HTTPHeaders: port.HTTPGet.HTTPHeaders,
-					}
+			}
+		}
+	}
+	return data
+}
+
+// clusterEndpointIPFamilies returns the families of the IP address hosts, IPv4 first.
+// DNS name hosts are left out: their addresses are only known after the first lookup and change with it,
+// the ipFamilies of the Service would flip between the default family, single-stack and dual-stack.
+func clusterEndpointIPFamilies(cep *v1beta1.ClusterEndpoint) []corev1.IPFamily {
+	found := sets.NewString()
+	for _, port := range cep.Spec.Ports {
+		for _, host := range portHosts(port) {
+			_, host = splitHost(port, host)
+			if net.ParseIP(host) == nil {
+				continue
+			}
+			if family, err := ipFamilyOf(host); err == nil {
+				found.Insert(string(family))
+			}
+		}
+	}
+	var families []corev1.IPFamily
+	for _, family := range []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol} {
+		if found.Has(string(family)) {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+func panicPolicy(cep *v1beta1.ClusterEndpoint) v1beta1.PanicPolicy {
+	if cep.Spec.PanicPolicy == "" {
+		return v1beta1.PanicPolicyPublishAll
+	}
+	return cep.Spec.PanicPolicy
+}
+
+// clusterEndpointResolveErrors returns the lookup errors of the DNS name hosts,
+// and whether the ClusterEndpoint has DNS name hosts at all.
+func clusterEndpointResolveErrors(cep *v1beta1.ClusterEndpoint, results *proberManager) ([]error, bool) {
+	var errors []error
+	hasNames := false
+	nn := client.ObjectKeyFromObject(cep)
+	for _, port := range cep.Spec.Ports {
+		for _, host := range portHosts(port) {
+			key := newProbeKey(nn, port, host)
+			if net.ParseIP(key.host) != nil || validateHost(host) != nil {
+				continue
+			}
+			hasNames = true
+			if r, ok := results.getResolution(key); ok && r.err != nil {
+				errors = append(errors, r.err)
+			}
+		}
+	}
+	return errors, hasNames
+}
+
+// clusterEndpointHostStatus reports the cached health of every address of every host,
+// an address is ready when it is part of the subsets and healthy when it passes its checks.
+func clusterEndpointHostStatus(cep *v1beta1.ClusterEndpoint, results *proberManager, subsets []corev1.EndpointSubset) []v1beta1.HostStatus {
+	var hosts []v1beta1.HostStatus
+	nn := client.ObjectKeyFromObject(cep)
+	drained := drainedHosts(cep)
+	now := time.Now()
+
+	for _, port := range cep.Spec.Ports {
+		for i, host := range portHosts(port) {
+			backup := i >= len(port.Hosts)
+			hostPort, _ := splitHost(port, host)
+			key := newProbeKey(nn, port, host)
+			addresses, ok := results.addresses(key)
+			if !ok {
+				// The DNS name has not been resolved yet, or the host is invalid.
+				status := v1beta1.HostStatus{PortName: port.Name, TargetPort: key.targetPort, Host: key.host, Backup: backup, Draining: drained.Has(host)}
+				if err := validateHost(host); err != nil {
+					status.LastError = err.Error()
+				} else if r, ok := results.getResolution(key); ok && r.err != nil {
+					status.LastError = r.err.Error()
 				}
-				if port.TCPSocket != nil && port.TCPSocket.Enable {
-					// add metrics point
-					pointList = append(pointList, metrics.Point{
-						Name:              cep.Name,
-						Namespace:         cep.Namespace,
-						TargetHostAndPort: host + ":" + strconv.Itoa(int(port.TargetPort)),
-						ProbeType:         metrics.TCP,
-					})
-					pro.TCPSocket = &libv1.TCPSocketAction{
-						Port: intstr.FromInt(int(port.TargetPort)),
-						Host: host,
-					}
+				hosts = append(hosts, status)
+				continue
+			}
+			for _, address := range addresses {
+				status := v1beta1.HostStatus{
+					PortName:   port.Name,
+					TargetPort: key.targetPort,
+					Host:       key.host,
+					Ready:      isEndpointPublished(subsets, hostPort, address),
+					// Hosts without checks are always healthy.
+					Healthy:  !hasChecks(port),
+					Backup:   backup,
+					Draining: isDrained(drained, host, key.withAddress(address)),
 				}
-				if port.UDPSocket != nil && port.UDPSocket.Enable {
-					// add metrics point
-					pointList = append(pointList, metrics.Point{
-						Name:              cep.Name,
-						Namespace:         cep.Namespace,
-						TargetHostAndPort: host + ":" + strconv.Itoa(int(port.TargetPort)),
-						ProbeType:         metrics.UDP,
-					})
-					pro.UDPSocket = &libv1.UDPSocketAction{
-						Port: intstr.FromInt(int(port.TargetPort)),
-						Host: host,
-						Data: v1beta1.Int8ArrToByteArr(port.UDPSocket.Data),
-					}
+				if address != key.host {
+					status.IP = address
 				}
-				if port.GRPC != nil && port.GRPC.Enable {
-					// add metrics point
-					pointList = append(pointList, metrics.Point{
-						Name:              cep.Name,
-						Namespace:         cep.Namespace,
-						TargetHostAndPort: host + ":" + strconv.Itoa(int(port.TargetPort)),
-						ProbeType:         metrics.GRPC,
-					})
-					pro.GRPC = &libv1.GRPCAction{
-						Port:    port.TargetPort,
-						Host:    host,
-						Service: port.GRPC.Service,
+				if result, ok := results.getResult(key.withAddress(address)); ok {
+					if !result.lastProbeTime.IsZero() {
+						status.LastProbeTime = metav1.NewTime(result.lastProbeTime)
 					}
-				}
-				w := &work{p: pro, retry: retry}
-				for w.doProbe() {
-				}
-				mx.Lock()
-				defer mx.Unlock()
-				err := w.err
-
-				var probe metrics.ProbeType
-				if w.p.ProbeHandler.Exec != nil {
-					probe = metrics.EXEC
-				} else if w.p.ProbeHandler.HTTPGet != nil {
-					probe = metrics.HTTP
-				} else if w.p.ProbeHandler.TCPSocket != nil {
-					probe = metrics.TCP
-				} else if w.p.ProbeHandler.UDPSocket != nil {
-					probe = metrics.UDP
-				} else if w.p.ProbeHandler.GRPC != nil {
-					probe = metrics.GRPC
-				}
-				klog.V(4).Info("[****] Probe is ", probe)
-
-				if err != nil {
-					// add metrics point
-					if metricsinfo != nil {
-						metricsinfo.RecordFailedCheck(cep.Name, cep.Namespace, host+":"+strconv.Itoa(int(port.TargetPort)), string(probe))
+					if !result.lastTransitionTime.IsZero() {
+						status.LastTransitionTime = metav1.NewTime(result.lastTransitionTime)
 					}
-					errors = append(errors, err)
-				} else {
-					// add metrics point
-					if metricsinfo != nil {
-						metricsinfo.RecordSuccessfulCheck(cep.Name, cep.Namespace, host+":"+strconv.Itoa(int(port.TargetPort)), string(probe))
+					status.Healthy = result.initialized && result.healthy
+					status.ConsecutiveFailures = result.consecutiveFailures
+					if result.err != nil {
+						status.LastError = result.err.Error()
 					}
-					data = append(data, port.ToEndpointSubset(host))
+					status.LastProbeLatency = metav1.Duration{Duration: result.latency}
+					status.Certificate = certificateStatus(port.Certificate, result.certificate, now)
+					status.Checks = checkStatuses(result.checks)
+					status.Flapping = result.flapping
 				}
-			}(p, h)
+				hosts = append(hosts, status)
+			}
 		}
 	}
-	wg.Wait()
-	for _, point := range pointList {
-		if metricsinfo != nil {
-			metricsinfo.RecordCheck(point.Name, point.Namespace, point.TargetHostAndPort, string(point.ProbeType))
+	return hosts
+}
+
+func checkStatuses(checks []checkResult) []v1beta1.CheckStatus {
+	var statuses []v1beta1.CheckStatus
+	for _, c := range checks {
+		status := v1beta1.CheckStatus{Name: c.name, Ready: c.ready, LastProbeLatency: metav1.Duration{Duration: c.latency}}
+		if c.err != nil {
+			status.LastError = c.err.Error()
 		}
-		//metricsinfo.RecordCeps(checkdata.NsName)
+		statuses = append(statuses, status)
 	}
-	return data, errors
+	return statuses
+}
+
+func certificateStatus(check *v1beta1.CertificateCheck, info *certificateInfo, now time.Time) *v1beta1.CertificateStatus {
+	if info == nil {
+		return nil
+	}
+	if info.err != nil {
+		return &v1beta1.CertificateStatus{LastError: info.err.Error()}
+	}
+	return &v1beta1.CertificateStatus{
+		NotAfter:        metav1.NewTime(info.notAfter),
+		Issuer:          info.issuer,
+		SubjectAltNames: info.sans,
+		Expiring:        certificateExpiring(check, info, now),
+	}
+}
+
+// recordExpiringCertificates raises a Warning event for every host whose certificate started expiring.
+func (c *Reconciler) recordExpiringCertificates(cep *v1beta1.ClusterEndpoint, hosts []v1beta1.HostStatus) {
+	expiring := sets.NewString()
+	for _, host := range cep.Status.Hosts {
+		if host.Certificate != nil && host.Certificate.Expiring {
+			expiring.Insert(hostStatusKey(host))
+		}
+	}
+	for _, host := range hosts {
+		if host.Certificate == nil || !host.Certificate.Expiring || expiring.Has(hostStatusKey(host)) {
+			continue
+		}
+		c.recorder.Eventf(cep, corev1.EventTypeWarning, "CertificateExpiring", "Certificate of %s is about to expire: %s",
+			hostStatusKey(host), certificateExpiringMessage(&certificateInfo{notAfter: host.Certificate.NotAfter.Time, issuer: host.Certificate.Issuer}, time.Now()))
+	}
+}
+
+// recordFailovers raises an event for every port that started or stopped publishing its backup hosts.
+func (c *Reconciler) recordFailovers(cep *v1beta1.ClusterEndpoint, hosts []v1beta1.HostStatus) {
+	before := backupPorts(cep.Status.Hosts)
+	after := backupPorts(hosts)
+	for _, port := range cep.Spec.Ports {
+		name := portStatusKey(port.Name, port.TargetPort)
+		switch {
+		case after.Has(port.Name) && !before.Has(port.Name):
+			c.recorder.Eventf(cep, corev1.EventTypeWarning, "FailedOver", "No host of port %s is healthy, failed over to the backup hosts %v", name, port.BackupHosts)
+		case before.Has(port.Name) && !after.Has(port.Name):
+			c.recorder.Eventf(cep, corev1.EventTypeNormal, "FailedBack", "Port %s failed back to the hosts %v", name, port.Hosts)
+		}
+	}
+}
+
+// backupPorts returns the names of the ports that publish their backup hosts.
+// Hosts can override the target port, the name is what identifies the port.
+func backupPorts(hosts []v1beta1.HostStatus) sets.String {
+	ports := sets.NewString()
+	for _, host := range hosts {
+		if host.Backup && host.Ready {
+			ports.Insert(host.PortName)
+		}
+	}
+	return ports
+}
+
+func portStatusKey(name string, targetPort int32) string {
+	if name == "" {
+		return strconv.Itoa(int(targetPort))
+	}
+	return name + "/" + strconv.Itoa(int(targetPort))
+}
+
+// hostStatusKey returns the address:port the status is about, for messages.
+func hostStatusKey(host v1beta1.HostStatus) string {
+	address := host.Host
+	if host.IP != "" {
+		address = host.IP
+	}
+	return net.JoinHostPort(address, strconv.Itoa(int(host.TargetPort)))
 }
@@ -0,0 +1,270 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// endpointSliceManagedBy is the value of the managed-by label of the EndpointSlices we write.
+	endpointSliceManagedBy = "endpoints-operator.sealos.io"
+	// maxEndpointsPerSlice is the default number of endpoints per slice of the EndpointSlice controller.
+	maxEndpointsPerSlice = 100
+	// maxEndpointSliceNameLength keeps the names of the slices valid DNS labels.
+	maxEndpointSliceNameLength = 63
+)
+
+func (c *Reconciler) syncEndpointSlice(ctx context.Context, cep *v1beta1.ClusterEndpoint, subsets []corev1.EndpointSubset) {
+	sliceCondition := v1beta1.Condition{
+		Type:               v1beta1.SyncEndpointSliceReady,
+		Status:             corev1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             string(v1beta1.SyncEndpointSliceReady),
+		Message:            "sync endpointslice successfully",
+	}
+	var desired []discoveryv1.EndpointSlice
+	if mode := endpointMode(cep); mode == v1beta1.EndpointModeEndpointSlice || mode == v1beta1.EndpointModeBoth {
+		desired = clusterEndpointConvertEndpointSlices(cep, subsets)
+	}
+	if err := c.updateEndpointSlices(ctx, cep, desired); err != nil {
+		sliceCondition.LastHeartbeatTime = metav1.Now()
+		sliceCondition.Status = corev1.ConditionFalse
+		sliceCondition.Reason = "EndpointSliceSyncError"
+		sliceCondition.Message = err.Error()
+		c.updateCondition(cep, sliceCondition)
+		c.logger.V(4).Info("error updating endpointslice", "name", cep.Name, "msg", err.Error())
+		return
+	}
+	if len(desired) == 0 {
+		removeCondition(cep, v1beta1.SyncEndpointSliceReady)
+		return
+	}
+	if !isConditionTrue(cep, v1beta1.SyncEndpointSliceReady) {
+		c.updateCondition(cep, sliceCondition)
+	}
+}
+
+// updateEndpointSlices applies the desired EndpointSlices with server-side apply and deletes the ones we wrote before
+// that are no longer desired. Slices that already have the desired content are not written.
+func (c *Reconciler) updateEndpointSlices(ctx context.Context, cep *v1beta1.ClusterEndpoint, desired []discoveryv1.EndpointSlice) error {
+	existing, err := c.listEndpointSlices(ctx, cep)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*discoveryv1.EndpointSlice, len(existing))
+	for i := range existing {
+		if err := c.upgradeManagedFields(ctx, &existing[i]); err != nil {
+			return err
+		}
+		current[existing[i].Name] = &existing[i]
+	}
+	names := sets.NewString()
+	for i := range desired {
+		want := &desired[i]
+		names.Insert(want.Name)
+		if slice, ok := current[want.Name]; ok && endpointSliceUpToDate(cep, slice, want) {
+			continue
+		}
+		slice := want.DeepCopy()
+		slice.TypeMeta = metav1.TypeMeta{APIVersion: "discovery.k8s.io/v1", Kind: "EndpointSlice"}
+		if err := controllerutil.SetControllerReference(cep, slice, c.scheme); err != nil {
+			return err
+		}
+		if err := c.Patch(ctx, slice, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+			return err
+		}
+	}
+	for i := range existing {
+		if names.Has(existing[i].Name) || !metav1.IsControlledBy(&existing[i], cep) {
+			continue
+		}
+		if err := c.Delete(ctx, &existing[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endpointSliceUpToDate reports whether applying the desired slice would leave the slice unchanged.
+func endpointSliceUpToDate(cep *v1beta1.ClusterEndpoint, slice, want *discoveryv1.EndpointSlice) bool {
+	if !metav1.IsControlledBy(slice, cep) || slice.AddressType != want.AddressType {
+		return false
+	}
+	for k, v := range want.Labels {
+		if slice.Labels[k] != v {
+			return false
+		}
+	}
+	return equality.Semantic.DeepEqual(slice.Ports, want.Ports) && equality.Semantic.DeepEqual(slice.Endpoints, want.Endpoints)
+}
+
+func (c *Reconciler) listEndpointSlices(ctx context.Context, cep *v1beta1.ClusterEndpoint) ([]discoveryv1.EndpointSlice, error) {
+	list := &discoveryv1.EndpointSliceList{}
+	if err := c.List(ctx, list, client.InNamespace(cep.Namespace), client.MatchingLabels{
+		discoveryv1.LabelServiceName: cep.Name,
+		discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+	}); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// clusterEndpointConvertEndpointSlices groups the hosts of the subsets per port and address type,
+// and splits every group into slices of at most maxEndpointsPerSlice endpoints.
+func clusterEndpointConvertEndpointSlices(cep *v1beta1.ClusterEndpoint, subsets []corev1.EndpointSubset) []discoveryv1.EndpointSlice {
+	type group struct {
+		port        corev1.EndpointPort
+		addressType discoveryv1.AddressType
+		endpoints   []discoveryv1.Endpoint
+	}
+	var keys []string
+	groups := make(map[string]*group)
+	add := func(port corev1.EndpointPort, address string, ready bool) {
+		addressType := addressTypeOf(address)
+		key := fmt.Sprintf("%s/%s/%d/%s", port.Name, port.Protocol, port.Port, addressType)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{port: port, addressType: addressType}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.endpoints = append(g.endpoints, discoveryv1.Endpoint{
+			Addresses: []string{address},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready:       pointer.Bool(ready),
+				Serving:     pointer.Bool(ready),
+				Terminating: pointer.Bool(false),
+			},
+		})
+	}
+	for _, subset := range subsets {
+		for _, port := range subset.Ports {
+			for _, addr := range subset.Addresses {
+				add(port, addr.IP, true)
+			}
+			for _, addr := range subset.NotReadyAddresses {
+				add(port, addr.IP, false)
+			}
+		}
+	}
+
+	var slices []discoveryv1.EndpointSlice
+	for _, key := range keys {
+		g := groups[key]
+		for i := 0; i*maxEndpointsPerSlice < len(g.endpoints); i++ {
+			end := (i + 1) * maxEndpointsPerSlice
+			if end > len(g.endpoints) {
+				end = len(g.endpoints)
+			}
+			slices = append(slices, discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      endpointSliceName(cep.Name, g.port, g.addressType, i),
+					Namespace: cep.Namespace,
+					Labels: map[string]string{
+						discoveryv1.LabelServiceName: cep.Name,
+						discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+					},
+				},
+				AddressType: g.addressType,
+				Ports: []discoveryv1.EndpointPort{
+					{
+						Name:     pointer.String(g.port.Name),
+						Port:     pointer.Int32(g.port.Port),
+						Protocol: protocolPtr(g.port.Protocol),
+					},
+				},
+				Endpoints: g.endpoints[i*maxEndpointsPerSlice : end],
+			})
+		}
+	}
+	return slices
+}
+
+// endpointSlicesToSubsets converts the EndpointSlices back into one subset per endpoint and port.
+func endpointSlicesToSubsets(slices []discoveryv1.EndpointSlice) []corev1.EndpointSubset {
+	var subsets []corev1.EndpointSubset
+	for _, slice := range slices {
+		for _, port := range slice.Ports {
+			epPort := corev1.EndpointPort{Name: pointer.StringDeref(port.Name, ""), Port: pointer.Int32Deref(port.Port, 0)}
+			if port.Protocol != nil {
+				epPort.Protocol = *port.Protocol
+			}
+			for _, endpoint := range slice.Endpoints {
+				subset := corev1.EndpointSubset{Ports: []corev1.EndpointPort{epPort}}
+				for _, address := range endpoint.Addresses {
+					if pointer.BoolDeref(endpoint.Conditions.Ready, true) {
+						subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: address})
+					} else {
+						subset.NotReadyAddresses = append(subset.NotReadyAddresses, corev1.EndpointAddress{IP: address})
+					}
+				}
+				subsets = append(subsets, subset)
+			}
+		}
+	}
+	return subsets
+}
+
+func addressTypeOf(address string) discoveryv1.AddressType {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return discoveryv1.AddressTypeFQDN
+	case ip.To4() != nil:
+		return discoveryv1.AddressTypeIPv4
+	default:
+		return discoveryv1.AddressTypeIPv6
+	}
+}
+
+// endpointSliceName returns a stable name for the nth slice of a port and address type.
+// The name of the ClusterEndpoint is cut so that the name fits in maxEndpointSliceNameLength,
+// the hash covers the full name then so that long names sharing a prefix do not collide.
+func endpointSliceName(name string, port corev1.EndpointPort, addressType discoveryv1.AddressType, n int) string {
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s/%s/%d", port.Name, port.Protocol, port.Port)
+	suffix := fmt.Sprintf("-%08x-%s-%d", h.Sum32(), strings.ToLower(string(addressType)), n)
+	if len(name)+len(suffix) <= maxEndpointSliceNameLength {
+		return name + suffix
+	}
+	_, _ = fmt.Fprintf(h, "/%s", name)
+	suffix = fmt.Sprintf("-%08x-%s-%d", h.Sum32(), strings.ToLower(string(addressType)), n)
+	return strings.TrimRight(name[:maxEndpointSliceNameLength-len(suffix)], "-.") + suffix
+}
+
+func protocolPtr(protocol corev1.Protocol) *corev1.Protocol {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	return &protocol
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_clusterEndpointConvertEndpointSlices(t *testing.T) {
+	port := v1beta1.ServicePort{Name: "default", Protocol: "TCP", Port: 80, TargetPort: 8080}
+	cep := &v1beta1.ClusterEndpoint{ObjectMeta: v1.ObjectMeta{Name: "cep", Namespace: "default"}}
+	var subsets []corev1.EndpointSubset
+	for i := 0; i < 150; i++ {
+		subsets = append(subsets, port.ToEndpointSubset(fmt.Sprintf("10.0.%d.%d", i/250, i%250)))
+	}
+	subsets = append(subsets, port.ToNotReadyEndpointSubset("fd00::1"))
+
+	slices := clusterEndpointConvertEndpointSlices(cep, subsets)
+	if len(slices) != 3 {
+		t.Fatalf("expected 3 slices, got %d", len(slices))
+	}
+	want := []struct {
+		addressType discoveryv1.AddressType
+		endpoints   int
+	}{
+		{discoveryv1.AddressTypeIPv4, 100},
+		{discoveryv1.AddressTypeIPv4, 50},
+		{discoveryv1.AddressTypeIPv6, 1},
+	}
+	names := map[string]bool{}
+	for i, slice := range slices {
+		if slice.AddressType != want[i].addressType || len(slice.Endpoints) != want[i].endpoints {
+			t.Errorf("slice %d: got %s with %d endpoints, want %s with %d", i, slice.AddressType, len(slice.Endpoints), want[i].addressType, want[i].endpoints)
+		}
+		if slice.Labels[discoveryv1.LabelServiceName] != cep.Name {
+			t.Errorf("slice %d: missing service name label", i)
+		}
+		names[slice.Name] = true
+	}
+	if len(names) != len(slices) {
+		t.Errorf("expected unique slice names, got %v", names)
+	}
+	if ready := slices[2].Endpoints[0].Conditions.Ready; ready == nil || *ready {
+		t.Errorf("expected not ready endpoint for fd00::1")
+	}
+}
+
+func Test_endpointSliceName(t *testing.T) {
+	port := corev1.EndpointPort{Name: "default", Protocol: "TCP", Port: 8080}
+	if name := endpointSliceName("cep", port, discoveryv1.AddressTypeIPv4, 0); !strings.HasPrefix(name, "cep-") || !strings.HasSuffix(name, "-ipv4-0") {
+		t.Errorf("unexpected name %s", name)
+	}
+	long := strings.Repeat("a", 60)
+	first := endpointSliceName(long+"-first", port, discoveryv1.AddressTypeIPv6, 10)
+	second := endpointSliceName(long+"-second", port, discoveryv1.AddressTypeIPv6, 10)
+	if len(first) > maxEndpointSliceNameLength || len(second) > maxEndpointSliceNameLength {
+		t.Errorf("names %s and %s are longer than %d", first, second, maxEndpointSliceNameLength)
+	}
+	if first == second {
+		t.Errorf("expected different names for different ClusterEndpoints, got %s", first)
+	}
+}
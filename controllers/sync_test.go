@@ -17,20 +17,96 @@ limitations under the License.
 package controllers
 
 import (
-	"github.com/labring/endpoints-operator/utils/metrics"
+	"context"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func Test_clusterEndpointConvertEndpointSubset(t *testing.T) {
+	tcpPort := v1beta1.ServicePort{
+		Hosts: []string{"172.18.1.38", "172.18.1.69", "172.18.2.18"},
+		Handler: v1beta1.Handler{
+			TCPSocket: &v1beta1.TCPSocketAction{Enable: true},
+		},
+		TimeoutSeconds:   1,
+		SuccessThreshold: 1,
+		FailureThreshold: 1,
+		Name:             "default",
+		Protocol:         "TCP",
+		Port:             8080,
+		TargetPort:       31381,
+	}
+	cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "cep",
+			Namespace: "default",
+		},
+		Spec: v1beta1.ClusterEndpointSpec{
+			Ports: []v1beta1.ServicePort{tcpPort},
+		},
+	}
+	nn := client.ObjectKeyFromObject(cep)
+	probeErr := errors.New("dial tcp 172.18.1.69:31381: connect: connection refused")
+
+	dnsPort := tcpPort
+	dnsPort.Hosts = []string{"db.example.com"}
+	dnsCep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: cep.ObjectMeta,
+		Spec: v1beta1.ClusterEndpointSpec{
+			Ports: []v1beta1.ServicePort{dnsPort},
+		},
+	}
+	dnsKey := newProbeKey(nn, dnsPort, "db.example.com")
+
+	backupPort := tcpPort
+	backupPort.Hosts = []string{"172.18.1.38"}
+	backupPort.BackupHosts = []string{"172.18.2.18"}
+	backupCep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: cep.ObjectMeta,
+		Spec: v1beta1.ClusterEndpointSpec{
+			Ports: []v1beta1.ServicePort{backupPort},
+		},
+	}
+
+	v6Port := tcpPort
+	v6Port.Hosts = []string{"fd00::1", "172.18.1.38", "db.example.com"}
+	v6Cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: cep.ObjectMeta,
+		Spec: v1beta1.ClusterEndpointSpec{
+			Ports:      []v1beta1.ServicePort{v6Port},
+			IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+		},
+	}
+	v6Key := newProbeKey(nn, v6Port, "db.example.com")
+
+	hostPortPort := tcpPort
+	hostPortPort.Hosts = []string{"172.18.1.38", "172.18.1.38:31382", "[fd00::1]:31383"}
+	hostPortCep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: cep.ObjectMeta,
+		Spec: v1beta1.ClusterEndpointSpec{
+			Ports: []v1beta1.ServicePort{hostPortPort},
+		},
+	}
+	overridden := func(targetPort int32, host string) corev1.EndpointSubset {
+		port := hostPortPort
+		port.TargetPort = targetPort
+		return port.ToEndpointSubset(host)
+	}
+
 	type args struct {
 		cep         *v1beta1.ClusterEndpoint
-		retry       int
-		metricsinfo *metrics.MetricsInfo
+		results     map[probeKey]probeResult
+		resolutions map[probeKey]resolution
+		published   []corev1.EndpointSubset
 	}
 	tests := []struct {
 		name  string
@@ -40,79 +116,224 @@ func Test_clusterEndpointConvertEndpointSubset(t *testing.T) {
 	}{
 		{
 			name: "default",
+			args: args{
+				cep: cep,
+			},
+			want:  nil,
+			want1: nil,
+		},
+		{
+			name: "endpoint",
+			args: args{
+				cep: cep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, tcpPort, "172.18.1.38"): {initialized: true, ready: true},
+					newProbeKey(nn, tcpPort, "172.18.1.69"): {initialized: true, ready: false, err: probeErr},
+					newProbeKey(nn, tcpPort, "172.18.2.18"): {initialized: true, ready: true},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				tcpPort.ToEndpointSubset("172.18.1.38"),
+				tcpPort.ToEndpointSubset("172.18.2.18"),
+			},
+			want1: []error{unhealthyError(newProbeKey(nn, tcpPort, "172.18.1.69"))},
+		},
+		{
+			name: "published",
+			args: args{
+				cep: cep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, tcpPort, "172.18.1.38"): {initialized: true, ready: true},
+				},
+				published: []corev1.EndpointSubset{
+					tcpPort.ToEndpointSubset("172.18.1.69"),
+				},
+			},
+			want: []corev1.EndpointSubset{
+				tcpPort.ToEndpointSubset("172.18.1.38"),
+				tcpPort.ToEndpointSubset("172.18.1.69"),
+			},
+			want1: nil,
+		},
+		{
+			name: "not ready addresses",
 			args: args{
 				cep: &v1beta1.ClusterEndpoint{
-					TypeMeta: v1.TypeMeta{},
-					ObjectMeta: v1.ObjectMeta{
-						Name:      "",
-						Namespace: "",
+					ObjectMeta: cep.ObjectMeta,
+					Spec: v1beta1.ClusterEndpointSpec{
+						Ports:          []v1beta1.ServicePort{tcpPort},
+						NotReadyPolicy: v1beta1.NotReadyPolicyNotReadyAddresses,
 					},
+				},
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, tcpPort, "172.18.1.38"): {initialized: true, ready: true},
+					newProbeKey(nn, tcpPort, "172.18.1.69"): {initialized: true, ready: false, err: probeErr},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				tcpPort.ToEndpointSubset("172.18.1.38"),
+				tcpPort.ToNotReadyEndpointSubset("172.18.1.69"),
+				tcpPort.ToNotReadyEndpointSubset("172.18.2.18"),
+			},
+			want1: []error{unhealthyError(newProbeKey(nn, tcpPort, "172.18.1.69"))},
+		},
+		{
+			name: "without probe",
+			args: args{
+				cep: &v1beta1.ClusterEndpoint{
+					ObjectMeta: cep.ObjectMeta,
 					Spec: v1beta1.ClusterEndpointSpec{
 						Ports: []v1beta1.ServicePort{
 							{
-								Hosts: []string{"172.18.1.38", "172.18.1.69", "172.18.2.18"},
-								Handler: v1beta1.Handler{
-									TCPSocket: &v1beta1.TCPSocketAction{Enable: true},
-								},
-								TimeoutSeconds:   1,
-								SuccessThreshold: 1,
-								FailureThreshold: 1,
-								Name:             "default",
-								Protocol:         "TCP",
-								Port:             8080,
-								TargetPort:       31381,
+								Hosts:      []string{"172.31.13.241"},
+								Name:       "default",
+								Protocol:   "TCP",
+								Port:       8848,
+								TargetPort: 8848,
 							},
 						},
-						PeriodSeconds: 0,
 					},
 				},
-				retry:       3,
-				metricsinfo: nil,
 			},
-			want:  nil,
+			want: []corev1.EndpointSubset{
+				{
+					Addresses: []corev1.EndpointAddress{{IP: "172.31.13.241"}},
+					Ports:     []corev1.EndpointPort{{Name: "default", Port: 8848, Protocol: "TCP"}},
+				},
+			},
 			want1: nil,
 		},
 		{
-			name: "endpoint",
+			name: "dns name",
+			args: args{
+				cep: dnsCep,
+				results: map[probeKey]probeResult{
+					dnsKey.withAddress("10.0.0.1"): {initialized: true, ready: true},
+					dnsKey.withAddress("10.0.0.2"): {initialized: true, ready: false, err: probeErr},
+				},
+				resolutions: map[probeKey]resolution{
+					dnsKey: {addresses: []string{"10.0.0.1", "10.0.0.2"}},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				dnsPort.ToEndpointSubset("10.0.0.1"),
+			},
+			want1: []error{unhealthyError(dnsKey.withAddress("10.0.0.2"))},
+		},
+		{
+			name: "dns name unresolved",
+			args: args{
+				cep: dnsCep,
+				published: []corev1.EndpointSubset{
+					dnsPort.ToEndpointSubset("10.0.0.1"),
+				},
+			},
+			want: []corev1.EndpointSubset{
+				dnsPort.ToEndpointSubset("10.0.0.1"),
+			},
+			want1: nil,
+		},
+		{
+			name: "ip families",
+			args: args{
+				cep: v6Cep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, v6Port, "fd00::1"):     {initialized: true, ready: true},
+					newProbeKey(nn, v6Port, "172.18.1.38"): {initialized: true, ready: true},
+					v6Key.withAddress("10.0.0.1"):          {initialized: true, ready: true},
+					v6Key.withAddress("fd00::2"):           {initialized: true, ready: true},
+				},
+				resolutions: map[probeKey]resolution{
+					v6Key: {addresses: []string{"10.0.0.1", "fd00::2"}},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				v6Port.ToEndpointSubset("fd00::1"),
+				v6Port.ToEndpointSubset("fd00::2"),
+			},
+			want1: []error{errors.New("IPv4 address 172.18.1.38 is not one of the ipFamilies [IPv6]")},
+		},
+		{
+			name: "host ports",
+			args: args{
+				cep: hostPortCep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, hostPortPort, "172.18.1.38"):       {initialized: true, ready: true},
+					newProbeKey(nn, hostPortPort, "172.18.1.38:31382"): {initialized: true, ready: true},
+					newProbeKey(nn, hostPortPort, "[fd00::1]:31383"):   {initialized: true, ready: true},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				hostPortPort.ToEndpointSubset("172.18.1.38"),
+				overridden(31382, "172.18.1.38"),
+				overridden(31383, "fd00::1"),
+			},
+			want1: nil,
+		},
+		{
+			name: "drained",
 			args: args{
 				cep: &v1beta1.ClusterEndpoint{
-					TypeMeta: v1.TypeMeta{},
 					ObjectMeta: v1.ObjectMeta{
-						Name:      "",
-						Namespace: "",
+						Name:        cep.Name,
+						Namespace:   cep.Namespace,
+						Annotations: map[string]string{v1beta1.DrainAnnotation: "172.18.2.18"},
 					},
 					Spec: v1beta1.ClusterEndpointSpec{
-						Ports: []v1beta1.ServicePort{
-							{
-								Hosts: []string{"172.31.13.241", "172.31.3.240", "172.31.4.233"},
-								Handler: v1beta1.Handler{
-									HTTPGet: &v1beta1.HTTPGetAction{
-										Path:   "/",
-										Scheme: "HTTP",
-									},
-								},
-								TimeoutSeconds:   1,
-								SuccessThreshold: 1,
-								FailureThreshold: 3,
-								Name:             "default",
-								Protocol:         "TCP",
-								Port:             8848,
-								TargetPort:       8848,
-							},
-						},
-						PeriodSeconds: 0,
+						Ports:        []v1beta1.ServicePort{tcpPort},
+						DrainedHosts: []string{"172.18.1.69"},
 					},
 				},
-				retry:       3,
-				metricsinfo: nil,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, tcpPort, "172.18.1.38"): {initialized: true, ready: true},
+					newProbeKey(nn, tcpPort, "172.18.1.69"): {initialized: true, ready: true},
+					newProbeKey(nn, tcpPort, "172.18.2.18"): {initialized: true, ready: true},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				tcpPort.ToEndpointSubset("172.18.1.38"),
+			},
+			want1: nil,
+		},
+		{
+			name: "primary healthy",
+			args: args{
+				cep: backupCep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, backupPort, "172.18.1.38"): {initialized: true, ready: true},
+					newProbeKey(nn, backupPort, "172.18.2.18"): {initialized: true, ready: true},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				backupPort.ToEndpointSubset("172.18.1.38"),
 			},
-			want:  nil,
 			want1: nil,
 		},
+		{
+			name: "failed over",
+			args: args{
+				cep: backupCep,
+				results: map[probeKey]probeResult{
+					newProbeKey(nn, backupPort, "172.18.1.38"): {initialized: true, ready: false, err: probeErr},
+					newProbeKey(nn, backupPort, "172.18.2.18"): {initialized: true, ready: true},
+				},
+			},
+			want: []corev1.EndpointSubset{
+				backupPort.ToEndpointSubset("172.18.2.18"),
+			},
+			want1: []error{unhealthyError(newProbeKey(nn, backupPort, "172.18.1.38"))},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1 := clusterEndpointConvertEndpointSubset(tt.args.cep, tt.args.retry, tt.args.metricsinfo)
+			m := newProberManager(1, nil)
+			for key, result := range tt.args.results {
+				m.setResult(key, result)
+			}
+			for key, r := range tt.args.resolutions {
+				m.setResolution(key, r)
+			}
+			got, got1 := clusterEndpointConvertEndpointSubset(tt.args.cep, m, tt.args.published)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("clusterEndpointConvertEndpointSubset() got = %v, want %v", got, tt.want)
 			}
@@ -122,3 +343,486 @@ func Test_clusterEndpointConvertEndpointSubset(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertEndpointSubsetHostReady(t *testing.T) {
+	port := v1beta1.ServicePort{
+		Hosts:      []string{"172.18.1.38", "172.18.1.69"},
+		Handler:    v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+		Name:       "default",
+		Protocol:   "TCP",
+		TargetPort: 80,
+	}
+	cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: v1.ObjectMeta{Name: "cep", Namespace: "default"},
+		Spec:       v1beta1.ClusterEndpointSpec{Ports: []v1beta1.ServicePort{port}, MinHealthyPercent: 100},
+	}
+	nn := client.ObjectKeyFromObject(cep)
+	published := &corev1.Endpoints{
+		ObjectMeta: v1.ObjectMeta{Name: "cep", Namespace: "default"},
+		Subsets:    []corev1.EndpointSubset{port.ToEndpointSubset("172.18.1.69")},
+	}
+	tests := []struct {
+		name   string
+		paused bool
+		// ready of 172.18.1.38 and 172.18.1.69.
+		want [2]bool
+	}{
+		// 172.18.1.69 fails, below minHealthyPercent every host is published.
+		{name: "panic", want: [2]bool{true, true}},
+		// Nothing is written, what the Endpoints publish stays.
+		{name: "paused", paused: true, want: [2]bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newProberManager(1, nil)
+			m.setResult(newProbeKey(nn, port, "172.18.1.38"), probeResult{initialized: true, ready: true, healthy: true})
+			m.setResult(newProbeKey(nn, port, "172.18.1.69"), probeResult{initialized: true, err: errors.New("connection refused")})
+			r := &Reconciler{
+				Client:   fake.NewClientBuilder().WithObjects(published.DeepCopy()).Build(),
+				recorder: record.NewFakeRecorder(10),
+				prober:   m,
+			}
+			cep := cep.DeepCopy()
+			_, _ = r.convertEndpointSubset(context.Background(), cep, tt.paused)
+			if len(cep.Status.Hosts) != 2 {
+				t.Fatalf("hosts = %+v, want 2", cep.Status.Hosts)
+			}
+			for i, host := range cep.Status.Hosts {
+				if host.Ready != tt.want[i] {
+					t.Errorf("ready of %s = %v, want %v", host.Host, host.Ready, tt.want[i])
+				}
+			}
+			if !cep.Status.Hosts[0].Healthy || cep.Status.Hosts[1].Healthy {
+				t.Errorf("healthy = %v, %v, want true, false", cep.Status.Hosts[0].Healthy, cep.Status.Hosts[1].Healthy)
+			}
+		})
+	}
+}
+
+func Test_clusterEndpointHealthyAddresses(t *testing.T) {
+	port := v1beta1.ServicePort{
+		Hosts:       []string{"172.18.1.38", "172.18.1.69"},
+		BackupHosts: []string{"172.18.2.18", "172.18.2.19", "172.18.2.20"},
+		Handler:     v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+		Name:        "default",
+		TargetPort:  31381,
+	}
+	cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: v1.ObjectMeta{Name: "cep", Namespace: "default"},
+		Spec:       v1beta1.ClusterEndpointSpec{Ports: []v1beta1.ServicePort{port}},
+	}
+	tests := []struct {
+		name    string
+		subsets []corev1.EndpointSubset
+		healthy int
+	}{
+		{name: "none", healthy: 0},
+		{name: "one", subsets: []corev1.EndpointSubset{port.ToEndpointSubset("172.18.1.38"), port.ToNotReadyEndpointSubset("172.18.1.69")}, healthy: 1},
+		{name: "backup", subsets: []corev1.EndpointSubset{
+			port.ToEndpointSubset("172.18.2.18"), port.ToEndpointSubset("172.18.2.19"), port.ToEndpointSubset("172.18.2.20"),
+		}, healthy: 2},
+	}
+	m := newProberManager(1, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthy, total := clusterEndpointHealthyAddresses(cep, m, tt.subsets)
+			if healthy != tt.healthy || total != 2 {
+				t.Errorf("clusterEndpointHealthyAddresses() = %d/%d, want %d/2", healthy, total, tt.healthy)
+			}
+		})
+	}
+}
+
+func Test_setServiceSpec(t *testing.T) {
+	local := corev1.ServiceExternalTrafficPolicyLocal
+	dualStack := corev1.IPFamilyPolicyPreferDualStack
+	tests := []struct {
+		name            string
+		cepSpec         v1beta1.ClusterEndpointSpec
+		hostFamilies    []corev1.IPFamily
+		currentFamilies []corev1.IPFamily
+		want            corev1.ServiceSpec
+	}{
+		{
+			name: "defaults",
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+			},
+		},
+		{
+			name: "cluster ip drops node settings",
+			cepSpec: v1beta1.ClusterEndpointSpec{
+				ClusterIP:                "None",
+				ExternalTrafficPolicy:    local,
+				LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+			},
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				ClusterIP:       "None",
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+			},
+		},
+		{
+			// The API server picks the family, a single IPv6 host must not be rejected on an IPv4 cluster.
+			name:         "new service",
+			hostFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+			},
+		},
+		{
+			name:            "primary family of the service",
+			hostFamilies:    []corev1.IPFamily{corev1.IPv6Protocol},
+			currentFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+			},
+		},
+		{
+			// An IPv4 host added to an IPv6 Service is appended, the primary family cannot change.
+			name:            "appended family",
+			hostFamilies:    []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			currentFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+				IPFamilies:      []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol},
+				IPFamilyPolicy:  &dualStack,
+			},
+		},
+		{
+			name: "spec families",
+			cepSpec: v1beta1.ClusterEndpointSpec{
+				IPFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+			},
+			hostFamilies:    []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol},
+			currentFamilies: []corev1.IPFamily{corev1.IPv4Protocol},
+			want: corev1.ServiceSpec{
+				Ports:           []corev1.ServicePort{{Name: "http", Port: 80}},
+				Type:            corev1.ServiceTypeClusterIP,
+				SessionAffinity: corev1.ServiceAffinityNone,
+				IPFamilies:      []corev1.IPFamily{corev1.IPv6Protocol},
+			},
+		},
+		{
+			name: "load balancer",
+			cepSpec: v1beta1.ClusterEndpointSpec{
+				Type:                     corev1.ServiceTypeLoadBalancer,
+				SessionAffinity:          corev1.ServiceAffinityClientIP,
+				SessionAffinityConfig:    &corev1.SessionAffinityConfig{},
+				ExternalTrafficPolicy:    local,
+				LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+			},
+			want: corev1.ServiceSpec{
+				Ports:                    []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}},
+				Type:                     corev1.ServiceTypeLoadBalancer,
+				SessionAffinity:          corev1.ServiceAffinityClientIP,
+				SessionAffinityConfig:    &corev1.SessionAffinityConfig{},
+				ExternalTrafficPolicy:    local,
+				LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}}}
+			setServiceSpec(&spec, tt.cepSpec, tt.hostFamilies, tt.currentFamilies)
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("setServiceSpec() = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clusterEndpointIPFamilies(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		want  []corev1.IPFamily
+	}{
+		{name: "dns names", hosts: []string{"db.example.com", "db.example.com:5432"}},
+		{name: "ipv4", hosts: []string{"172.18.1.38", "db.example.com"}, want: []corev1.IPFamily{corev1.IPv4Protocol}},
+		{name: "dual-stack", hosts: []string{"[fd00::1]:8080", "172.18.1.38:8080"}, want: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cep := &v1beta1.ClusterEndpoint{Spec: v1beta1.ClusterEndpointSpec{Ports: []v1beta1.ServicePort{{Name: "default", TargetPort: 80, Hosts: tt.hosts}}}}
+			if got := clusterEndpointIPFamilies(cep); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clusterEndpointIPFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ipFamilyOf(t *testing.T) {
+	tests := []struct {
+		address string
+		want    corev1.IPFamily
+		wantErr bool
+	}{
+		{address: "172.18.1.38", want: corev1.IPv4Protocol},
+		{address: "fd00::1", want: corev1.IPv6Protocol},
+		{address: "::ffff:172.18.1.38", wantErr: true},
+		{address: "fe80::1%eth0", wantErr: true},
+		{address: "db.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			got, err := ipFamilyOf(tt.address)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ipFamilyOf() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_splitHost(t *testing.T) {
+	port := v1beta1.ServicePort{Name: "default", TargetPort: 3306}
+	tests := []struct {
+		host       string
+		want       string
+		targetPort int32
+	}{
+		{host: "172.18.1.38", want: "172.18.1.38", targetPort: 3306},
+		{host: "172.18.1.38:3307", want: "172.18.1.38", targetPort: 3307},
+		{host: "fd00::1", want: "fd00::1", targetPort: 3306},
+		{host: "[fd00::1]:3307", want: "fd00::1", targetPort: 3307},
+		{host: "db.example.com:3307", want: "db.example.com", targetPort: 3307},
+		{host: "db.example.com:0", want: "db.example.com:0", targetPort: 3306},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, host := splitHost(port, tt.host)
+			if host != tt.want || got.TargetPort != tt.targetPort {
+				t.Errorf("splitHost() = %s, %d, want %s, %d", host, got.TargetPort, tt.want, tt.targetPort)
+			}
+		})
+	}
+}
+
+func Test_validateHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{host: "172.18.1.38", wantErr: false},
+		{host: "fd00::1", wantErr: false},
+		{host: "[fd00::1]:3307", wantErr: false},
+		{host: "db.example.com", wantErr: false},
+		{host: "db.example.com:3307", wantErr: false},
+		{host: "[fd00::1]", wantErr: true},
+		{host: "[db.example.com]:3307", wantErr: true},
+		{host: "db.example.com:0", wantErr: true},
+		{host: "db.example.com:mysql", wantErr: true},
+		{host: "172.18.1.38:65536", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if err := validateHost(tt.host); (err != nil) != tt.wantErr {
+				t.Errorf("validateHost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_repackSubsets(t *testing.T) {
+	mysql := v1beta1.ServicePort{Name: "mysql", Protocol: "TCP", TargetPort: 3306}
+	admin := v1beta1.ServicePort{Name: "admin", Protocol: "TCP", TargetPort: 8080}
+	subsets := []corev1.EndpointSubset{
+		mysql.ToEndpointSubset("10.0.0.2"),
+		admin.ToEndpointSubset("10.0.0.2"),
+		mysql.ToEndpointSubset("fd00::1"),
+		mysql.ToEndpointSubset("10.0.0.1"),
+		admin.ToEndpointSubset("10.0.0.1"),
+		mysql.ToEndpointSubset("10.0.0.3"),
+		admin.ToNotReadyEndpointSubset("10.0.0.3"),
+	}
+	want := []corev1.EndpointSubset{
+		{
+			NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.3"}},
+			Ports:             []corev1.EndpointPort{{Name: "admin", Protocol: "TCP", Port: 8080}},
+		},
+		{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+			Ports: []corev1.EndpointPort{
+				{Name: "admin", Protocol: "TCP", Port: 8080},
+				{Name: "mysql", Protocol: "TCP", Port: 3306},
+			},
+		},
+		{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.3"}},
+			Ports:     []corev1.EndpointPort{{Name: "mysql", Protocol: "TCP", Port: 3306}},
+		},
+		{
+			Addresses: []corev1.EndpointAddress{{IP: "fd00::1"}},
+			Ports:     []corev1.EndpointPort{{Name: "mysql", Protocol: "TCP", Port: 3306}},
+		},
+	}
+	got := repackSubsets(subsets)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repackSubsets() = %v, want %v", got, want)
+	}
+	// The order of the input does not matter.
+	for i, j := 0, len(subsets)-1; i < j; i, j = i+1, j-1 {
+		subsets[i], subsets[j] = subsets[j], subsets[i]
+	}
+	if got := repackSubsets(subsets); !reflect.DeepEqual(got, want) {
+		t.Errorf("repackSubsets() of reversed subsets = %v, want %v", got, want)
+	}
+	if got := repackSubsets(nil); got != nil {
+		t.Errorf("repackSubsets(nil) = %v, want nil", got)
+	}
+}
+
+func Test_serviceUpToDate(t *testing.T) {
+	desired := &corev1.Service{TypeMeta: v1.TypeMeta{APIVersion: "v1", Kind: "Service"}}
+	desired.SetName("cep")
+	desired.SetNamespace("default")
+	desired.Spec.Ports = convertServicePorts([]v1beta1.ServicePort{{Name: "http", Port: 80}})
+	desired.Spec.Type = corev1.ServiceTypeClusterIP
+
+	current := desired.DeepCopy()
+	// Defaulted by the API server, not owned by us.
+	current.Spec.ClusterIP = "10.96.0.10"
+	current.Spec.SessionAffinity = corev1.ServiceAffinityNone
+	current.ManagedFields = []v1.ManagedFieldsEntry{{
+		Manager:    fieldManager,
+		Operation:  v1.ManagedFieldsOperationApply,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &v1.FieldsV1{Raw: []byte(`{"f:spec":{"f:ports":{"k:{\"port\":80,\"protocol\":\"TCP\"}":{".":{},"f:name":{},"f:port":{},"f:protocol":{},"f:targetPort":{}}},"f:type":{}}}`)},
+	}}
+	if !serviceUpToDate(current, desired) {
+		t.Errorf("serviceUpToDate() = false, want true")
+	}
+	changed := desired.DeepCopy()
+	changed.Spec.Type = corev1.ServiceTypeNodePort
+	if serviceUpToDate(current, changed) {
+		t.Errorf("serviceUpToDate() of a changed type = true, want false")
+	}
+	current.ManagedFields = nil
+	if serviceUpToDate(current, desired) {
+		t.Errorf("serviceUpToDate() without managed fields = true, want false")
+	}
+}
+
+func Test_statusNeedsUpdateFailingProbes(t *testing.T) {
+	port := v1beta1.ServicePort{
+		Hosts:      []string{"172.18.1.38", "172.18.1.69"},
+		Handler:    v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+		Name:       "default",
+		TargetPort: 80,
+	}
+	cep := &v1beta1.ClusterEndpoint{
+		ObjectMeta: v1.ObjectMeta{Name: "cep", Namespace: "default"},
+		Spec:       v1beta1.ClusterEndpointSpec{Ports: []v1beta1.ServicePort{port}},
+	}
+	nn := client.ObjectKeyFromObject(cep)
+	now := time.Now()
+	// status builds the status of a probe of 172.18.1.69 failing with the error, the way reconcile does.
+	status := func(probeErr error) *v1beta1.ClusterEndpointStatus {
+		m := newProberManager(1, nil)
+		m.setResult(newProbeKey(nn, port, "172.18.1.38"), probeResult{initialized: true, ready: true, healthy: true})
+		m.setResult(newProbeKey(nn, port, "172.18.1.69"), probeResult{initialized: true, err: probeErr})
+		subsets, errs := clusterEndpointConvertEndpointSubset(cep, m, nil)
+		return &v1beta1.ClusterEndpointStatus{
+			Conditions: []v1beta1.Condition{
+				{Type: v1beta1.Ready, Status: corev1.ConditionTrue, LastHeartbeatTime: v1.NewTime(now)},
+				{Type: v1beta1.SyncEndpointReady, Status: corev1.ConditionFalse, Reason: "EndpointSyncPortError", Message: ToAggregate(errs).Error()},
+			},
+			Hosts: clusterEndpointHostStatus(cep, m, subsets),
+		}
+	}
+	first := status(errors.New("read tcp 10.0.0.5:41234->172.18.1.69:80: read: connection reset by peer"))
+	second := status(errors.New("read tcp 10.0.0.5:41236->172.18.1.69:80: read: connection reset by peer"))
+	if statusNeedsUpdate(first, second, now) {
+		t.Errorf("statusNeedsUpdate() of probe errors that differ in the local port = true, want false")
+	}
+}
+
+func Test_upgradeManagedFields(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "cep", ManagedFields: []v1.ManagedFieldsEntry{
+		{
+			Manager:    fieldManager,
+			Operation:  v1.ManagedFieldsOperationUpdate,
+			APIVersion: "v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &v1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{".":{},"f:app":{}}},"f:spec":{"f:type":{}}}`)},
+		},
+		{
+			Manager:    "kubectl-edit",
+			Operation:  v1.ManagedFieldsOperationUpdate,
+			APIVersion: "v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &v1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{".":{},"f:note":{}}}}`)},
+		},
+	}}}
+	r := &Reconciler{Client: fake.NewClientBuilder().WithObjects(svc).Build()}
+	current := &corev1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), current); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.upgradeManagedFields(context.Background(), current); err != nil {
+		t.Fatalf("upgradeManagedFields() error = %v", err)
+	}
+	got := &corev1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), got); err != nil {
+		t.Fatal(err)
+	}
+	operations := map[string]v1.ManagedFieldsOperationType{}
+	for _, f := range got.ManagedFields {
+		operations[f.Manager] = f.Operation
+	}
+	want := map[string]v1.ManagedFieldsOperationType{fieldManager: v1.ManagedFieldsOperationApply, "kubectl-edit": v1.ManagedFieldsOperationUpdate}
+	if !reflect.DeepEqual(operations, want) {
+		t.Errorf("managed fields after upgradeManagedFields() = %v, want %v", operations, want)
+	}
+	if err := r.upgradeManagedFields(context.Background(), got); err != nil {
+		t.Errorf("upgradeManagedFields() of an upgraded object error = %v", err)
+	}
+}
+
+func Test_statusNeedsUpdate(t *testing.T) {
+	now := time.Now()
+	written := v1.NewTime(now.Add(-10 * time.Second))
+	current := &v1beta1.ClusterEndpointStatus{
+		Conditions: []v1beta1.Condition{{Type: v1beta1.Ready, Status: corev1.ConditionTrue, LastHeartbeatTime: written}},
+		Hosts:      []v1beta1.HostStatus{{Host: "172.18.1.38", TargetPort: 80, Ready: true, LastProbeTime: written}},
+	}
+	probed := current.DeepCopy()
+	probed.Hosts[0].LastProbeTime = v1.NewTime(now)
+	flipped := probed.DeepCopy()
+	flipped.Hosts[0].Ready = false
+	failed := probed.DeepCopy()
+	failed.Hosts[0].LastError = "read tcp 10.0.0.5:41234->172.18.1.38:80: read: connection reset by peer"
+	failed.Hosts[0].ConsecutiveFailures = 1
+	failedAgain := failed.DeepCopy()
+	failedAgain.Hosts[0].LastError = "read tcp 10.0.0.5:41236->172.18.1.38:80: read: connection reset by peer"
+
+	if statusNeedsUpdate(current, current.DeepCopy(), now) {
+		t.Errorf("statusNeedsUpdate() of the same status = true, want false")
+	}
+	if statusNeedsUpdate(current, probed, now) {
+		t.Errorf("statusNeedsUpdate() of a new probe time = true, want false")
+	}
+	if !statusNeedsUpdate(current, probed, now.Add(statusRefreshInterval)) {
+		t.Errorf("statusNeedsUpdate() of a new probe time after the refresh interval = false, want true")
+	}
+	if !statusNeedsUpdate(current, flipped, now) {
+		t.Errorf("statusNeedsUpdate() of a health flip = false, want true")
+	}
+	if statusNeedsUpdate(current, failed, now) {
+		t.Errorf("statusNeedsUpdate() of a failed probe below the threshold = true, want false")
+	}
+	if statusNeedsUpdate(failed, failedAgain, now) {
+		t.Errorf("statusNeedsUpdate() of an error with another local port = true, want false")
+	}
+}
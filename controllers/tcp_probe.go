@@ -0,0 +1,80 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+)
+
+// maxTCPExpectLength caps how much is read from the connection while waiting for the expected data.
+const maxTCPExpectLength = 4 * 1 << 10 // 4KB
+
+func tcpCheckEnabled(action *v1beta1.TCPSocketAction) bool {
+	return action.Send != "" || action.ExpectRegex != "" || action.ExpectPrefix != ""
+}
+
+// tcpCheck writes the send data of the action and waits for the expected data, like the tcp-check of HAProxy.
+// Everything has to happen within the timeout.
+func tcpCheck(host string, port int, action *v1beta1.TCPSocketAction, timeout time.Duration) (probe.Result, string, error) {
+	var re *regexp.Regexp
+	if action.ExpectRegex != "" {
+		var err error
+		if re, err = regexp.Compile(action.ExpectRegex); err != nil {
+			return probe.Unknown, "", err
+		}
+	}
+	conn, output := dialProbe(host, port, timeout)
+	if conn == nil {
+		return probe.Failure, output, nil
+	}
+	defer conn.Close()
+
+	if action.Send != "" {
+		if _, err := conn.Write([]byte(action.Send)); err != nil {
+			return probe.Failure, fmt.Sprintf("failed to send data: %v", err), nil
+		}
+	}
+	if re == nil && action.ExpectPrefix == "" {
+		return probe.Success, "", nil
+	}
+
+	prefix := []byte(action.ExpectPrefix)
+	var data []byte
+	buf := make([]byte, 512)
+	for len(data) < maxTCPExpectLength {
+		n, err := conn.Read(buf)
+		data = append(data, buf[:n]...)
+		prefixOK := len(prefix) == 0 || bytes.HasPrefix(data, prefix)
+		if prefixOK && (re == nil || re.Match(data)) {
+			return probe.Success, string(data), nil
+		}
+		if len(prefix) != 0 && len(data) >= len(prefix) && !prefixOK {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+	return probe.Failure, fmt.Sprintf("unexpected response %s", strconv.Quote(string(data))), nil
+}
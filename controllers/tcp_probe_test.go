@@ -0,0 +1,59 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+)
+
+func TestTCPCheck(t *testing.T) {
+	smtp := func(conn net.Conn) {
+		_, _ = conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "QUIT\r\n" {
+			_, _ = conn.Write([]byte("221 Bye\r\n"))
+		}
+	}
+	tests := []struct {
+		name   string
+		action v1beta1.TCPSocketAction
+		want   probe.Result
+	}{
+		{name: "prefix", action: v1beta1.TCPSocketAction{ExpectPrefix: "220 "}, want: probe.Success},
+		{name: "wrong prefix", action: v1beta1.TCPSocketAction{ExpectPrefix: "421 "}, want: probe.Failure},
+		{name: "send and regex", action: v1beta1.TCPSocketAction{Send: "QUIT\r\n", ExpectRegex: `(?m)^221 `}, want: probe.Success},
+		{name: "no answer", action: v1beta1.TCPSocketAction{ExpectRegex: `^\+OK`}, want: probe.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := serveOnce(t, smtp)
+			got, output, err := tcpCheck("127.0.0.1", port, &tt.action, 200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("tcpCheck() got = %v (%q), want %v", got, output, tt.want)
+			}
+		})
+	}
+}
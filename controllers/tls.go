@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+)
+
+// handlerTLSConfig returns the TLS config of the handler.
+func handlerTLSConfig(h v1beta1.Handler) *v1beta1.TLSConfig {
+	if h.HTTPGet != nil {
+		return h.HTTPGet.TLS
+	}
+	if h.GRPC != nil && h.GRPC.Enable {
+		return h.GRPC.TLS
+	}
+	if h.PostgreSQL != nil && h.PostgreSQL.Enable {
+		return h.PostgreSQL.TLS
+	}
+	return nil
+}
+
+// buildTLSConfig creates the client config of a probe against the host.
+func buildTLSConfig(cfg *v1beta1.TLSConfig, m *portMaterial, host string) (*tls.Config, error) {
+	if m == nil {
+		return nil, errors.New("tls material has not been loaded")
+	}
+	if m.err != "" {
+		return nil, errors.New(m.err)
+	}
+	config := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // nolint: gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.ServerName == "" && net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	switch cfg.MinVersion {
+	case "TLS10":
+		config.MinVersion = tls.VersionTLS10
+	case "TLS11":
+		config.MinVersion = tls.VersionTLS11
+	case "TLS13":
+		config.MinVersion = tls.VersionTLS13
+	}
+	if len(m.ca) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(m.ca) {
+			return nil, errors.New("no certificate found in the ca bundle")
+		}
+		config.RootCAs = pool
+	}
+	if len(m.cert) != 0 || len(m.key) != 0 {
+		cert, err := tls.X509KeyPair(m.cert, m.key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/endpoints-operator/utils/dns"
+	"github.com/labring/operator-sdk/probe"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxUDPResponseLength is the largest datagram read as response.
+const maxUDPResponseLength = 64 * 1 << 10 // 64KB
+
+var dnsQueryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// udpCheck sends the data of the action in a datagram and checks the response against the expectations of the action.
+// Any response is healthy when nothing is expected. An ICMP port unreachable is reported as failure right away,
+// instead of waiting for the timeout.
+func udpCheck(host string, port int, action *v1beta1.UDPSocketAction, timeout time.Duration) (probe.Result, string, error) {
+	if action.DNS != nil {
+		return dnsCheck(host, port, action.DNS, timeout)
+	}
+	var re *regexp.Regexp
+	if action.ExpectRegex != "" {
+		var err error
+		if re, err = regexp.Compile(action.ExpectRegex); err != nil {
+			return probe.Unknown, "", err
+		}
+	}
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return probe.Failure, err.Error(), nil
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(v1beta1.Int8ArrToByteArr(action.Data)); err != nil {
+		return probe.Failure, udpErrorOutput(err), nil
+	}
+	buf := make([]byte, maxUDPResponseLength)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return probe.Failure, udpErrorOutput(err), nil
+	}
+	data := buf[:n]
+	switch {
+	case n == 0 || n < int(action.MinLength):
+		return probe.Failure, fmt.Sprintf("response of %d bytes is shorter than %d bytes", n, action.MinLength), nil
+	case !bytes.HasPrefix(data, v1beta1.Int8ArrToByteArr(action.ExpectPrefix)),
+		re != nil && !re.Match(data):
+		return probe.Failure, fmt.Sprintf("unexpected response %s", strconv.Quote(string(data))), nil
+	}
+	return probe.Success, string(data), nil
+}
+
+// dnsCheck sends the query to the host, and expects a NOERROR response.
+func dnsCheck(host string, port int, query *v1beta1.DNSQuery, timeout time.Duration) (probe.Result, string, error) {
+	name := query.Type
+	if name == "" {
+		name = "A"
+	}
+	qtype, ok := dnsQueryTypes[name]
+	if !ok {
+		return probe.Unknown, "", fmt.Errorf("unsupported dns query type %q", query.Type)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msg, err := dns.Exchange(ctx, net.JoinHostPort(host, strconv.Itoa(port)), query.Name, qtype, timeout)
+	if err != nil {
+		return probe.Failure, udpErrorOutput(err), nil
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return probe.Failure, fmt.Sprintf("dns query %s %s returned %s", query.Name, name, msg.RCode), nil
+	}
+	return probe.Success, fmt.Sprintf("dns query %s %s returned %d answers", query.Name, name, len(msg.Answers)), nil
+}
+
+// udpErrorOutput explains the error of a datagram exchange. A refused connection means
+// the host answered with an ICMP port unreachable.
+func udpErrorOutput(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "port unreachable"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "no response within timeout"
+	}
+	return err.Error()
+}
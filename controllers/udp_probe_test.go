@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/operator-sdk/probe"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveUDP answers every datagram with the reply of the handler, nothing is sent for a nil reply.
+func serveUDP(t *testing.T, handle func(req []byte) []byte) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := handle(buf[:n]); reply != nil {
+				_, _ = conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPCheck(t *testing.T) {
+	echo := serveUDP(t, func(req []byte) []byte { return append([]byte("echo "), req...) })
+	silent := serveUDP(t, func(req []byte) []byte { return nil })
+	nameserver := serveUDP(t, func(req []byte) []byte {
+		var msg dnsmessage.Message
+		if err := msg.Unpack(req); err != nil {
+			return nil
+		}
+		msg.Response = true
+		if msg.Questions[0].Name.String() != "svc.example.com." {
+			msg.RCode = dnsmessage.RCodeNameError
+		}
+		resp, _ := msg.Pack()
+		return resp
+	})
+	// A closed port, the kernel answers with an ICMP port unreachable.
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.LocalAddr().(*net.UDPAddr).Port
+	_ = l.Close()
+
+	tests := []struct {
+		name   string
+		port   int
+		action v1beta1.UDPSocketAction
+		want   probe.Result
+	}{
+		{name: "any response", port: echo, action: v1beta1.UDPSocketAction{Data: []uint8("ping")}, want: probe.Success},
+		{name: "prefix", port: echo, action: v1beta1.UDPSocketAction{Data: []uint8("ping"), ExpectPrefix: []uint8("echo ")}, want: probe.Success},
+		{name: "regex mismatch", port: echo, action: v1beta1.UDPSocketAction{Data: []uint8("ping"), ExpectRegex: "pong$"}, want: probe.Failure},
+		{name: "too short", port: echo, action: v1beta1.UDPSocketAction{Data: []uint8("ping"), MinLength: 16}, want: probe.Failure},
+		{name: "no response", port: silent, action: v1beta1.UDPSocketAction{Data: []uint8("ping")}, want: probe.Failure},
+		{name: "port unreachable", port: closed, action: v1beta1.UDPSocketAction{Data: []uint8("ping")}, want: probe.Failure},
+		{name: "dns", port: nameserver, action: v1beta1.UDPSocketAction{DNS: &v1beta1.DNSQuery{Name: "svc.example.com"}}, want: probe.Success},
+		{name: "dns nxdomain", port: nameserver, action: v1beta1.UDPSocketAction{DNS: &v1beta1.DNSQuery{Name: "other.example.com", Type: "TXT"}}, want: probe.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, output, err := udpCheck("127.0.0.1", tt.port, &tt.action, 200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("udpCheck() got = %v (%q), want %v", got, output, tt.want)
+			}
+		})
+	}
+}
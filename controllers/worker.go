@@ -0,0 +1,490 @@
+/*
+Copyright 2022 The sealos Authors.
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"github.com/labring/endpoints-operator/utils/metrics"
+	libv1 "github.com/labring/operator-sdk/api/core/v1"
+	"github.com/labring/operator-sdk/probe"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// worker handles the periodic probing of a single host of a ClusterEndpoint port.
+// A DNS name host is re-resolved once its records expire, and every address it
+// resolved to is probed on its own.
+// Consecutive results are counted across periods, and the cached result is only
+// changed once the success or failure threshold has been reached.
+type worker struct {
+	// Channel for stopping the probe.
+	stopCh chan struct{}
+
+	key       probeKey
+	port      v1beta1.ServicePort
+	materials map[string]*portMaterial
+	period    time.Duration
+
+	probeManager *proberManager
+
+	// The addresses of the host, and when they have to be resolved again.
+	targets map[string]*target
+	expiry  time.Time
+}
+
+// target is a single address of the host.
+type target struct {
+	owner *worker
+	key   probeKey
+	// probe holds the thresholds of the port, it is nil when the address is not checked at all.
+	probe  *libv1.Probe
+	checks []*check
+	policy v1beta1.CheckPolicy
+	// certificate is the config of the handshake reading the certificate, nil when it is not checked.
+	certificate      *tls.Config
+	certificateCheck *v1beta1.CertificateCheck
+	// flapDetection and minReady hold a recovering address out of the endpoints.
+	flapDetection *v1beta1.FlapDetection
+	minReady      time.Duration
+
+	// The health once the thresholds are reached, before damping, and since when it holds.
+	healthy      bool
+	healthySince time.Time
+	// The recent changes of healthy, while flap detection is enabled.
+	transitions []time.Time
+
+	// The last probe result for this target.
+	lastResult probe.Result
+	// How many times in a row the probe has returned the same result.
+	resultRun int
+	// The result published to the manager.
+	result probeResult
+}
+
+// check is one of the checks run against a target. The name is empty when the port
+// is probed with its own handler.
+type check struct {
+	name      string
+	probe     *libv1.Probe
+	probeType metrics.ProbeType
+	endpoint  endpoint
+}
+
+// newWorker returns a worker for the host of the key, it takes over the results of the host from any previous worker.
+func newWorker(m *proberManager, key probeKey, port v1beta1.ServicePort, materials map[string]*portMaterial, period time.Duration) *worker {
+	w := &worker{
+		stopCh:       make(chan struct{}, 1), // Buffer so stop() can be non-blocking.
+		key:          key,
+		port:         port,
+		materials:    materials,
+		period:       period,
+		probeManager: m,
+		targets:      make(map[string]*target),
+	}
+	m.setOwner(w)
+	return w
+}
+
+// loadResults picks up the cached addresses and results of the host, so that a restarted worker keeps its health.
+func (w *worker) loadResults() {
+	addresses, _ := w.probeManager.addresses(w.key)
+	for _, address := range addresses {
+		t := w.newTarget(address)
+		t.result, _ = w.probeManager.getResult(t.key)
+		if t.certificate == nil && t.result.certificate != nil {
+			// The port stopped checking the certificate.
+			t.result.certificate = nil
+			w.probeManager.forgetCertificate(t.key)
+		}
+		w.targets[address] = t
+	}
+}
+
+func (w *worker) newTarget(address string) *target {
+	t := &target{
+		owner:         w,
+		key:           w.key.withAddress(address),
+		policy:        w.port.CheckPolicy,
+		flapDetection: w.port.FlapDetection,
+		minReady:      time.Duration(w.port.MinReadySeconds) * time.Second,
+		lastResult:    probe.Unknown,
+	}
+	var probeTLS *tls.Config
+	for _, spec := range portChecks(w.port) {
+		if c := w.newCheck(spec, address); c != nil {
+			t.checks = append(t.checks, c)
+			if probeTLS == nil {
+				probeTLS = c.endpoint.tls
+			}
+		}
+	}
+	if certificateCheckEnabled(w.port) {
+		t.certificate = certificateTLSConfig(probeTLS, w.key.host)
+		t.certificateCheck = w.port.Certificate
+	}
+	if len(t.checks) != 0 || t.certificate != nil {
+		// The certificate may be the only thing checked.
+		t.probe = newProbeThresholds(w.port)
+	}
+	return t
+}
+
+// newCheck returns the check against the address, nil when the check has no handler enabled.
+func (w *worker) newCheck(spec v1beta1.Check, address string) *check {
+	port := checkPort(w.port, spec)
+	c := &check{
+		name:      spec.Name,
+		probe:     newProbe(port, address),
+		probeType: probeTypeOf(spec.Handler),
+		endpoint:  endpoint{host: address, port: w.port.TargetPort, handler: &port.Handler},
+	}
+	if c.probe == nil {
+		return nil
+	}
+	if c.probeType == metrics.EXEC && !w.probeManager.execEnabled {
+		c.endpoint.err = errExecProbeDisabled
+		return c
+	}
+	material := w.materials[spec.Name]
+	if material != nil {
+		c.endpoint.password = material.password
+		if material.err != "" {
+			c.endpoint.err = errors.New(material.err)
+		}
+	}
+	if cfg := handlerTLSConfig(spec.Handler); cfg != nil && c.endpoint.err == nil {
+		// Verify against the DNS name, not the address it resolved to.
+		c.endpoint.tls, c.endpoint.err = buildTLSConfig(cfg, material, w.key.host)
+	}
+	return c
+}
+
+// run periodically probes the host until stop is called.
+func (w *worker) run() {
+	probeTicker := time.NewTicker(w.period)
+	defer probeTicker.Stop()
+
+probeLoop:
+	for w.doProbe() {
+		// Wait for next probe tick.
+		select {
+		case <-w.stopCh:
+			break probeLoop
+		case <-probeTicker.C:
+			// continue
+		}
+	}
+}
+
+// stop stops the probe worker. The worker handles cleanup and removes itself from its manager.
+// It is safe to call stop multiple times.
+func (w *worker) stop() {
+	select {
+	case w.stopCh <- struct{}{}:
+	default: // Non-blocking.
+	}
+}
+
+// doProbe resolves the host if needed, probes all its addresses once and records the results.
+// Returns whether the worker should continue.
+// nolint: errcheck
+func (w *worker) doProbe() (keepGoing bool) {
+	defer func() { recover() }() // Actually eat panics (HandleCrash takes care of logging)
+	defer runtime.HandleCrash(func(_ interface{}) { keepGoing = true })
+
+	changed := w.resolve()
+
+	// Probe the addresses in parallel, so that a slow one does not delay the others.
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, t := range w.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			defer func() { recover() }()
+			defer runtime.HandleCrash()
+			if t.doProbe(w.probeManager) {
+				lock.Lock()
+				changed = true
+				lock.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	if changed {
+		// The host flipped, let the controller publish the new state.
+		return w.probeManager.enqueue(w.key, w.stopCh)
+	}
+	return true
+}
+
+// resolve looks the host up again once its records expired, and adds or drops targets to match.
+// On failure the previous addresses are kept. Returns whether the addresses or the lookup state changed.
+func (w *worker) resolve() bool {
+	if net.ParseIP(w.key.host) != nil {
+		if len(w.targets) == 0 {
+			w.targets[w.key.host] = w.newTarget(w.key.host)
+		}
+		return false
+	}
+	now := time.Now()
+	if now.Before(w.expiry) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.period)
+	defer cancel()
+	records, err := w.probeManager.resolver.LookupIP(ctx, w.key.host)
+	if err != nil {
+		klog.V(4).Infof("Resolve errored for %v: %v", w.key, err)
+		prev, _ := w.probeManager.getResolution(w.key)
+		return w.probeManager.setWorkerResolution(w, resolution{addresses: prev.addresses, err: err})
+	}
+
+	// Resolve again once the first record expires, at the earliest on the next period.
+	w.expiry = time.Time{}
+	for _, record := range records {
+		if expiry := now.Add(record.TTL); w.expiry.IsZero() || expiry.Before(w.expiry) {
+			w.expiry = expiry
+		}
+	}
+
+	addresses := sortedAddresses(records)
+	desired := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		desired[address] = true
+		if _, ok := w.targets[address]; !ok {
+			w.targets[address] = w.newTarget(address)
+		}
+	}
+	for address, t := range w.targets {
+		if !desired[address] {
+			delete(w.targets, address)
+			w.probeManager.removeTargetResult(w, t.key)
+		}
+	}
+	return w.probeManager.setWorkerResolution(w, resolution{addresses: addresses})
+}
+
+// checkCertificate reads the certificate of the address, and fails the probe when
+// the certificate is about to expire and the port asks for it.
+func (t *target) checkCertificate(m *proberManager, result probe.Result, output string) (probe.Result, string) {
+	info := readCertificate(t.key.address, t.key.targetPort, t.certificate, time.Duration(t.probe.TimeoutSeconds)*time.Second)
+	t.result.certificate = &info
+	if info.err != nil {
+		klog.V(4).Infof("Reading certificate errored for %v: %v", t.key, info.err)
+	} else {
+		m.recordCertificate(t.key, info)
+	}
+	check := t.certificateCheck
+	if check.ExpiryAction != v1beta1.CertificateExpiryActionFail || result == probe.Failure {
+		return result, output
+	}
+	if info.err != nil {
+		return probe.Failure, fmt.Sprintf("failed to read certificate: %v", info.err)
+	}
+	if now := time.Now(); certificateExpiring(check, &info, now) {
+		return probe.Failure, certificateExpiringMessage(&info, now)
+	}
+	return result, output
+}
+
+// runChecks runs all the checks of the target in parallel and combines their results with the policy.
+// The port's own handler is the only check when it has none, its result is returned as is.
+func (t *target) runChecks(m *proberManager) (probe.Result, string, error) {
+	if len(t.checks) == 0 {
+		return probe.Success, "", nil
+	}
+	type run struct {
+		result  probe.Result
+		output  string
+		err     error
+		latency time.Duration
+	}
+	runs := make([]run, len(t.checks))
+	var wg sync.WaitGroup
+	for i, c := range t.checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			start := time.Now()
+			result, output, err := proberCheck.runProbeWithRetries(c.probe, c.endpoint, m.retry)
+			runs[i] = run{result: result, output: output, err: err, latency: time.Since(start)}
+			m.recordMetrics(t.key, c.name, c.probeType, result, err, runs[i].latency)
+		}(i, c)
+	}
+	wg.Wait()
+	if len(t.checks) == 1 && t.checks[0].name == "" {
+		t.result.checks = nil
+		return runs[0].result, runs[0].output, runs[0].err
+	}
+
+	checks := make([]checkResult, len(t.checks))
+	var failed []string
+	succeeded := 0
+	for i, c := range t.checks {
+		r := runs[i]
+		checks[i] = checkResult{name: c.name, ready: true, latency: r.latency}
+		switch {
+		case r.err != nil:
+			checks[i].ready, checks[i].err = false, r.err
+		case r.result == probe.Failure || r.result == probe.Unknown:
+			if r.output == "" {
+				r.output = "probe failed"
+			}
+			checks[i].ready, checks[i].err = false, errors.New(r.output)
+		}
+		if checks[i].ready {
+			succeeded++
+		} else {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, checks[i].err))
+		}
+	}
+	t.result.checks = checks
+	if succeeded == len(checks) || (t.policy == v1beta1.CheckPolicyAny && succeeded > 0) {
+		return probe.Success, "", nil
+	}
+	return probe.Failure, strings.Join(failed, "; "), nil
+}
+
+// damp returns whether the address is published, given its health once the thresholds are reached.
+// A flapping address is held out until it stayed healthy for the stable period, and an address
+// that failed is held out until it stayed healthy for minReadySeconds.
+func (t *target) damp(healthy bool, now time.Time) bool {
+	if t.healthySince.IsZero() || t.healthy != healthy {
+		if !t.healthySince.IsZero() && t.flapDetection != nil {
+			t.transitions = append(t.transitions, now)
+		}
+		t.healthy = healthy
+		t.healthySince = now
+	}
+	stableFor := now.Sub(t.healthySince)
+
+	if f := t.flapDetection; f != nil && f.MaxTransitions > 0 {
+		window := time.Duration(f.WindowSeconds) * time.Second
+		if window <= 0 {
+			window = defaultFlapWindowSeconds * time.Second
+		}
+		stable := time.Duration(f.StableSeconds) * time.Second
+		if stable <= 0 {
+			stable = window
+		}
+		recent := t.transitions[:0]
+		for _, transition := range t.transitions {
+			if now.Sub(transition) < window {
+				recent = append(recent, transition)
+			}
+		}
+		t.transitions = recent
+		if len(t.transitions) > int(f.MaxTransitions) {
+			t.result.flapping = true
+		}
+		if t.result.flapping {
+			if !healthy || stableFor < stable {
+				t.result.err = fmt.Errorf("flapping, held out until healthy for %v", stable)
+				return false
+			}
+			t.result.flapping = false
+			t.transitions = nil
+		}
+	}
+	if healthy && t.minReady > 0 && t.result.initialized && !t.result.ready && stableFor < t.minReady {
+		t.result.err = fmt.Errorf("recovered, held out until healthy for %v", t.minReady)
+		return false
+	}
+	return healthy
+}
+
+// doProbe probes the address once and records the result.
+// Returns whether its health flipped.
+func (t *target) doProbe(m *proberManager) bool {
+	if t.probe == nil {
+		// Nothing to probe, the address is ready as soon as it is resolved.
+		if t.result.initialized {
+			return false
+		}
+		t.result.initialized = true
+		t.result.ready = true
+		t.result.healthy = true
+		t.result.lastTransitionTime = time.Now()
+		return m.setWorkerResult(t.owner, t.key, t.result)
+	}
+
+	start := time.Now()
+	result, output, err := t.runChecks(m)
+	latency := time.Since(start)
+	if t.certificate != nil {
+		result, output = t.checkCertificate(m, result, output)
+	}
+	switch {
+	case err != nil:
+		klog.V(4).Infof("Probe errored for %v: %v", t.key, err)
+		result = probe.Failure
+		output = err.Error()
+	case result == probe.Warning:
+		result = probe.Success
+	case result == probe.Unknown:
+		result = probe.Failure
+	}
+
+	if t.lastResult == result {
+		t.resultRun++
+	} else {
+		t.lastResult = result
+		t.resultRun = 1
+	}
+
+	t.result.lastProbeTime = start
+	t.result.latency = latency
+	t.result.consecutiveFailures = 0
+	if result == probe.Failure {
+		if len(output) == 0 {
+			output = "probe failed"
+		}
+		t.result.err = errors.New(output)
+		t.result.consecutiveFailures = int32(t.resultRun)
+	}
+
+	if (result == probe.Failure && t.resultRun < int(t.probe.FailureThreshold)) ||
+		(result == probe.Success && t.resultRun < int(t.probe.SuccessThreshold)) {
+		// Success or failure is below threshold - leave the probe state unchanged.
+		m.setWorkerResult(t.owner, t.key, t.result)
+		return false
+	}
+
+	t.result.healthy = result == probe.Success
+	ready := t.damp(t.result.healthy, start)
+	if !t.result.initialized || t.result.ready != ready {
+		t.result.lastTransitionTime = start
+	}
+	t.result.initialized = true
+	t.result.ready = ready
+	if ready {
+		t.result.err = nil
+	}
+	return m.setWorkerResult(t.owner, t.key, t.result)
+}
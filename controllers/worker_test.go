@@ -0,0 +1,187 @@
+/*
+Copyright 2022 The sealos Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/labring/endpoints-operator/apis/network/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestWorkerThreshold(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	port := v1beta1.ServicePort{
+		Hosts:            []string{"127.0.0.1"},
+		Handler:          v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+		SuccessThreshold: 1,
+		FailureThreshold: 2,
+		Name:             "default",
+		TargetPort:       int32(addr.Port),
+	}
+	m := newProberManager(1, nil)
+	key := newProbeKey(types.NamespacedName{Namespace: "default", Name: "cep"}, port, "127.0.0.1")
+	w := newWorker(m, key, workerPort(port, key), nil, time.Second)
+
+	w.doProbe()
+	if r, ok := m.getResult(key); !ok || !r.ready || !r.healthy {
+		t.Fatalf("expected host to be ready after first success, got %+v (cached: %v)", r, ok)
+	}
+	if len(m.Updates()) != 1 {
+		t.Fatalf("expected one update, got %d", len(m.Updates()))
+	}
+
+	_ = l.Close()
+	w.doProbe()
+	if r, _ := m.getResult(key); !r.ready {
+		t.Fatal("expected host to stay ready below the failure threshold")
+	}
+	w.doProbe()
+	if r, _ := m.getResult(key); r.ready || r.healthy || r.err == nil {
+		t.Fatalf("expected host to be not ready once the failure threshold is reached, got %+v", r)
+	}
+	if len(m.Updates()) != 2 {
+		t.Fatalf("expected two updates, got %d", len(m.Updates()))
+	}
+}
+
+func TestWorkerChecks(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+	port := v1beta1.ServicePort{
+		Hosts: []string{"127.0.0.1"},
+		Checks: []v1beta1.Check{
+			{Name: "connect", Handler: v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}}},
+			{Name: "banner", Handler: v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true, ExpectPrefix: "220"}}},
+		},
+		FailureThreshold: 1,
+		Name:             "default",
+		TargetPort:       int32(addr.Port),
+	}
+	for _, tt := range []struct {
+		policy v1beta1.CheckPolicy
+		ready  bool
+	}{
+		{policy: v1beta1.CheckPolicyAll, ready: false},
+		{policy: v1beta1.CheckPolicyAny, ready: true},
+	} {
+		port.CheckPolicy = tt.policy
+		m := newProberManager(1, nil)
+		key := newProbeKey(types.NamespacedName{Namespace: "default", Name: "cep"}, port, "127.0.0.1")
+		w := newWorker(m, key, workerPort(port, key), nil, time.Second)
+		w.doProbe()
+		r, _ := m.getResult(key)
+		if r.ready != tt.ready {
+			t.Errorf("%s: expected ready %v, got %+v", tt.policy, tt.ready, r)
+		}
+		if len(r.checks) != 2 || !r.checks[0].ready || r.checks[1].ready {
+			t.Errorf("%s: expected connect to succeed and banner to fail, got %+v", tt.policy, r.checks)
+		}
+	}
+}
+
+func TestTargetDamp(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	flapping := &target{flapDetection: &v1beta1.FlapDetection{MaxTransitions: 2, WindowSeconds: 60, StableSeconds: 30}}
+	for i, step := range []struct {
+		second  int
+		healthy bool
+		want    bool
+	}{
+		{second: 0, healthy: true, want: true},
+		{second: 10, healthy: false, want: false},
+		{second: 20, healthy: true, want: true},
+		{second: 30, healthy: false, want: false},
+		// The third transition within the window.
+		{second: 40, healthy: true, want: false},
+		{second: 60, healthy: true, want: false},
+		{second: 70, healthy: true, want: true},
+	} {
+		if got := flapping.damp(step.healthy, at(step.second)); got != step.want {
+			t.Errorf("flapping step %d: damp() = %v, want %v", i, got, step.want)
+		}
+		flapping.result.initialized, flapping.result.ready = true, step.want
+	}
+
+	recovering := &target{minReady: 30 * time.Second}
+	for i, step := range []struct {
+		second  int
+		healthy bool
+		want    bool
+	}{
+		{second: 0, healthy: true, want: true},
+		{second: 10, healthy: false, want: false},
+		{second: 20, healthy: true, want: false},
+		{second: 50, healthy: true, want: true},
+	} {
+		if got := recovering.damp(step.healthy, at(step.second)); got != step.want {
+			t.Errorf("recovering step %d: damp() = %v, want %v", i, got, step.want)
+		}
+		recovering.result.initialized, recovering.result.ready = true, step.want
+	}
+}
+
+func TestStoppedWorkerResults(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := v1beta1.ServicePort{
+		Hosts:            []string{"127.0.0.1"},
+		Handler:          v1beta1.Handler{TCPSocket: &v1beta1.TCPSocketAction{Enable: true}},
+		SuccessThreshold: 1,
+		FailureThreshold: 1,
+		Name:             "default",
+		TargetPort:       int32(l.Addr().(*net.TCPAddr).Port),
+	}
+	m := newProberManager(1, nil)
+	key := newProbeKey(types.NamespacedName{Namespace: "default", Name: "cep"}, port, "127.0.0.1")
+
+	// A probe still running when the host is removed must not bring its result back.
+	w := newWorker(m, key, workerPort(port, key), nil, time.Second)
+	m.removeResult(key)
+	w.doProbe()
+	if r, ok := m.getResult(key); ok {
+		t.Fatalf("expected no result of a removed host, got %+v", r)
+	}
+
+	// Nor overwrite the results of the worker that replaced it.
+	replaced := newWorker(m, key, workerPort(port, key), nil, time.Second)
+	w = newWorker(m, key, workerPort(port, key), nil, time.Second)
+	_ = l.Close()
+	replaced.doProbe()
+	if _, ok := m.getResult(key); ok {
+		t.Fatal("expected the result of a replaced worker to be dropped")
+	}
+	w.doProbe()
+	if r, ok := m.getResult(key); !ok || r.ready {
+		t.Fatalf("expected the result of the current worker, got %+v (cached: %v)", r, ok)
+	}
+}
@@ -16,10 +16,12 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/labring/endpoints-operator/apis/network/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 )
 
@@ -50,3 +52,28 @@ func (c *Cep) DeleteCR(ctx context.Context, namespace, name string) error {
 func (c *Cep) DeleteCRs(ctx context.Context, namespace string, options v1.ListOptions) error {
 	return c.client.Resource(c.gvr).Namespace(namespace).DeleteCollection(ctx, v1.DeleteOptions{}, options)
 }
+
+// GetAnnotations returns the annotations of the ClusterEndpoint, and its resourceVersion.
+func (c *Cep) GetAnnotations(ctx context.Context, namespace, name string) (map[string]string, string, error) {
+	obj, err := c.client.Resource(c.gvr).Namespace(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	return obj.GetAnnotations(), obj.GetResourceVersion(), nil
+}
+
+// PatchAnnotation sets the annotation of the ClusterEndpoint, or removes it when the value is nil.
+// The patch fails with a conflict when the ClusterEndpoint is no longer at the resourceVersion.
+func (c *Cep) PatchAnnotation(ctx context.Context, namespace, name, resourceVersion, key string, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": resourceVersion,
+			"annotations":     map[string]*string{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Resource(c.gvr).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,315 @@
+// Copyright © 2022 The sealos Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	hostsPath      = "/etc/hosts"
+	defaultTimeout = 2 * time.Second
+	defaultNdots   = 1
+	maxUDPSize     = 1232
+)
+
+// Record is an address a name resolved to.
+type Record struct {
+	IP  net.IP
+	TTL time.Duration
+}
+
+// Resolver looks up A and AAAA records and keeps their TTL, which net.Resolver does not expose.
+type Resolver struct {
+	// Servers are the nameservers, as host:port, that are asked in order.
+	Servers []string
+	// Search is the list of domains tried for names with less than Ndots dots.
+	Search []string
+	Ndots  int
+	// Timeout of a single query.
+	Timeout time.Duration
+	// Hosts are the addresses of the names in /etc/hosts, such as the hostAliases of the pod.
+	// They are returned without asking the nameservers, with a TTL of 0.
+	Hosts map[string][]net.IP
+}
+
+// NewResolver creates a Resolver from /etc/resolv.conf and /etc/hosts.
+func NewResolver() *Resolver {
+	r := &Resolver{Ndots: defaultNdots, Timeout: defaultTimeout}
+	if f, err := os.Open(resolvConfPath); err == nil {
+		r.parseResolvConf(f)
+		_ = f.Close()
+	}
+	if f, err := os.Open(hostsPath); err == nil {
+		r.Hosts = parseHosts(f)
+		_ = f.Close()
+	}
+	return r
+}
+
+// parseResolvConf sets the nameservers, the search list and ndots of the resolv.conf.
+func (r *Resolver) parseResolvConf(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			r.Servers = append(r.Servers, net.JoinHostPort(fields[1], "53"))
+		case "search":
+			r.Search = fields[1:]
+		case "options":
+			for _, opt := range fields[1:] {
+				if v, ok := strings.CutPrefix(opt, "ndots:"); ok {
+					if n, err := strconv.Atoi(v); err == nil {
+						r.Ndots = n
+					}
+				}
+			}
+		}
+	}
+}
+
+// parseHosts returns the addresses of every name of the hosts file, names are lower case without the trailing dot.
+func parseHosts(reader io.Reader) map[string][]net.IP {
+	hosts := make(map[string][]net.IP)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = hostsKey(name)
+			hosts[name] = append(hosts[name], ip)
+		}
+	}
+	return hosts
+}
+
+func hostsKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of the host.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]Record, error) {
+	if ips := r.Hosts[hostsKey(host)]; len(ips) != 0 {
+		records := make([]Record, 0, len(ips))
+		for _, ip := range ips {
+			records = append(records, Record{IP: ip})
+		}
+		return records, nil
+	}
+	if len(r.Servers) == 0 {
+		// Nothing to ask directly, fall back to the system resolver without TTL.
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]Record, 0, len(addrs))
+		for _, addr := range addrs {
+			records = append(records, Record{IP: addr.IP})
+		}
+		return records, nil
+	}
+	var lastErr error
+	for _, name := range r.candidates(host) {
+		var records []Record
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			msg, err := r.Query(ctx, name, qtype)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			records = append(records, answerRecords(msg)...)
+		}
+		if len(records) != 0 {
+			return records, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("lookup %s: %w", host, lastErr)
+	}
+	return nil, fmt.Errorf("lookup %s: no such host", host)
+}
+
+// Query sends a single question to the nameservers until one of them answers it.
+// A truncated answer is asked again over TCP, so that no address is left out.
+func (r *Resolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	var lastErr error
+	for _, server := range r.Servers {
+		msg, err := Exchange(ctx, server, name, qtype, r.Timeout)
+		if err == nil && msg.Truncated {
+			msg, err = ExchangeTCP(ctx, server, name, qtype, r.Timeout)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if msg.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("%s %s: %s", name, qtype, msg.RCode)
+		}
+		return msg, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no nameserver")
+	}
+	return nil, lastErr
+}
+
+// newQuery returns the packed question, with an EDNS0 record announcing the UDP payload size we read.
+func newQuery(name string, qtype dnsmessage.Type) (uint16, []byte, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return 0, nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return 0, nil, err
+	}
+	id := uint16(rand.Uint32())
+	req := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions:   []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}},
+	}
+	packed, err := req.Pack()
+	return id, packed, err
+}
+
+// Exchange sends a single question to the server over UDP and returns its response.
+// The response may be truncated, see ExchangeTCP.
+func Exchange(ctx context.Context, server, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsmessage.Message, error) {
+	id, packed, err := newQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil {
+			return nil, err
+		}
+		// Ignore stray responses to other queries.
+		if resp.ID == id && resp.Response {
+			return &resp, nil
+		}
+	}
+}
+
+// ExchangeTCP sends a single question to the server over TCP and returns its response.
+func ExchangeTCP(ctx context.Context, server, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsmessage.Message, error) {
+	id, packed, err := newQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	// Messages over TCP are prefixed with their length.
+	if _, err := conn.Write(append([]byte{byte(len(packed) >> 8), byte(len(packed))}, packed...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, int(length[0])<<8|int(length[1]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if resp.ID != id || !resp.Response {
+		return nil, errors.New("response does not match the query")
+	}
+	return &resp, nil
+}
+
+func answerRecords(msg *dnsmessage.Message) []Record {
+	var records []Record
+	for _, answer := range msg.Answers {
+		ttl := time.Duration(answer.Header.TTL) * time.Second
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			records = append(records, Record{IP: net.IP(body.A[:]), TTL: ttl})
+		case *dnsmessage.AAAAResource:
+			records = append(records, Record{IP: net.IP(body.AAAA[:]), TTL: ttl})
+		}
+	}
+	return records
+}
+
+// candidates returns the names to try for the host, following the search list like the libc resolver.
+func (r *Resolver) candidates(host string) []string {
+	if strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+	var names []string
+	for _, domain := range r.Search {
+		names = append(names, host+"."+strings.TrimSuffix(domain, "."))
+	}
+	if strings.Count(host, ".") >= r.Ndots {
+		return append([]string{host}, names...)
+	}
+	return append(names, host)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
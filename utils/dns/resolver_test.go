@@ -0,0 +1,183 @@
+// Copyright © 2022 The sealos Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	r := &Resolver{Ndots: defaultNdots}
+	r.parseResolvConf(strings.NewReader(`# generated by kubelet
+nameserver 10.96.0.10
+nameserver fd00::10
+search default.svc.cluster.local svc.cluster.local cluster.local
+options ndots:5 timeout:1
+`))
+	if want := []string{"10.96.0.10:53", "[fd00::10]:53"}; !reflect.DeepEqual(r.Servers, want) {
+		t.Errorf("Servers = %v, want %v", r.Servers, want)
+	}
+	if want := []string{"default.svc.cluster.local", "svc.cluster.local", "cluster.local"}; !reflect.DeepEqual(r.Search, want) {
+		t.Errorf("Search = %v, want %v", r.Search, want)
+	}
+	if r.Ndots != 5 {
+		t.Errorf("Ndots = %d, want 5", r.Ndots)
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts := parseHosts(strings.NewReader(`127.0.0.1 localhost
+# Entries added by HostAliases.
+10.33.40.151 db.example.com DB
+fd00::151 db.example.com # comment
+not-an-ip broken.example.com
+`))
+	want := map[string][]net.IP{
+		"localhost":      {net.ParseIP("127.0.0.1")},
+		"db.example.com": {net.ParseIP("10.33.40.151"), net.ParseIP("fd00::151")},
+		"db":             {net.ParseIP("10.33.40.151")},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("parseHosts() = %v, want %v", hosts, want)
+	}
+
+	r := &Resolver{Hosts: hosts}
+	records, err := r.LookupIP(context.Background(), "DB.example.com.")
+	if err != nil || len(records) != 2 || records[0].TTL != 0 {
+		t.Errorf("LookupIP() of a hosts entry = %v, %v, want both addresses without TTL", records, err)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	r := &Resolver{Search: []string{"default.svc.cluster.local.", "cluster.local"}, Ndots: 2}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{host: "db", want: []string{"db.default.svc.cluster.local", "db.cluster.local", "db"}},
+		{host: "db.example.com", want: []string{"db.example.com", "db.example.com.default.svc.cluster.local", "db.example.com.cluster.local"}},
+		{host: "db.example.com.", want: []string{"db.example.com."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := r.candidates(tt.host); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnswerRecords(t *testing.T) {
+	name := dnsmessage.MustNewName("db.example.com.")
+	msg := &dnsmessage.Message{Answers: []dnsmessage.Resource{
+		{Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeCNAME, TTL: 300}, Body: &dnsmessage.CNAMEResource{CNAME: name}},
+		{Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, TTL: 30}, Body: &dnsmessage.AResource{A: [4]byte{10, 33, 40, 151}}},
+		{Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeAAAA, TTL: 60}, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 15: 1}}},
+	}}
+	want := []Record{
+		{IP: net.IP{10, 33, 40, 151}, TTL: 30 * time.Second},
+		{IP: net.ParseIP("fd00::1"), TTL: time.Minute},
+	}
+	if got := answerRecords(msg); !reflect.DeepEqual(got, want) {
+		t.Errorf("answerRecords() = %v, want %v", got, want)
+	}
+}
+
+// response answers the query with the addresses, truncated to the first one when truncate is set.
+func response(t *testing.T, query []byte, truncate bool, addresses ...[4]byte) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		t.Errorf("unpack query: %v", err)
+		return nil
+	}
+	if len(req.Additionals) != 1 || req.Additionals[0].Header.Type != dnsmessage.TypeOPT {
+		t.Errorf("query without EDNS0 record: %+v", req.Additionals)
+	}
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, Truncated: truncate},
+		Questions: req.Questions,
+	}
+	if truncate {
+		addresses = addresses[:1]
+	}
+	for _, a := range addresses {
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: req.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		t.Errorf("pack response: %v", err)
+	}
+	return packed
+}
+
+func TestQueryTruncated(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Skipf("tcp port of the udp listener is taken: %v", err)
+	}
+	defer tcp.Close()
+	addresses := [][4]byte{{10, 0, 0, 1}, {10, 0, 0, 2}}
+
+	go func() {
+		buf := make([]byte, maxUDPSize)
+		n, addr, err := udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		_, _ = udp.WriteTo(response(t, buf[:n], true, addresses...), addr)
+	}()
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		buf := make([]byte, int(length[0])<<8|int(length[1]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		packed := response(t, buf, false, addresses...)
+		_, _ = conn.Write(append([]byte{byte(len(packed) >> 8), byte(len(packed))}, packed...))
+	}()
+
+	r := &Resolver{Servers: []string{udp.LocalAddr().String()}, Timeout: 2 * time.Second}
+	msg, err := r.Query(context.Background(), "db.example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Truncated || len(answerRecords(msg)) != 2 {
+		t.Errorf("Query() of a truncated answer = %+v, want both addresses over TCP", msg)
+	}
+}
@@ -32,11 +32,14 @@ const (
 	numCheckFailedKey       = "cep_num_check_failed"
 	numCheckSuccessfulKey   = "cep_num_check_successful"
 	checkDurationSecondsKey = "cep_check_duration_seconds"
+	certificateNotAfterKey  = "cep_certificate_not_after_seconds"
 
 	cepLabel   = "name"
 	nameSpaces = "namespaces"
 	instance   = "instance"
 	probe      = "probe"
+	check      = "check"
+	issuer     = "issuer"
 )
 
 func NewMetricsInfo() *MetricsInfo {
@@ -55,7 +58,7 @@ func NewMetricsInfo() *MetricsInfo {
 					Name: numCheckedKey,
 					Help: "Total number of check",
 				},
-				[]string{cepLabel, nameSpaces, instance, probe},
+				[]string{cepLabel, nameSpaces, instance, probe, check},
 			),
 
 			numCheckFailedKey: prometheus.NewCounterVec(
@@ -63,7 +66,7 @@ func NewMetricsInfo() *MetricsInfo {
 					Name: numCheckFailedKey,
 					Help: "Total number of failed check",
 				},
-				[]string{cepLabel, nameSpaces, instance, probe},
+				[]string{cepLabel, nameSpaces, instance, probe, check},
 			),
 
 			numCheckSuccessfulKey: prometheus.NewCounterVec(
@@ -71,7 +74,7 @@ func NewMetricsInfo() *MetricsInfo {
 					Name: numCheckSuccessfulKey,
 					Help: "Total number of successful check",
 				},
-				[]string{cepLabel, nameSpaces, instance, probe},
+				[]string{cepLabel, nameSpaces, instance, probe, check},
 			),
 
 			checkDurationSecondsKey: prometheus.NewHistogramVec(
@@ -98,7 +101,15 @@ func NewMetricsInfo() *MetricsInfo {
 						toSeconds(10 * time.Hour),
 					},
 				},
-				[]string{cepLabel, nameSpaces, instance, probe},
+				[]string{cepLabel, nameSpaces, instance, probe, check},
+			),
+
+			certificateNotAfterKey: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: certificateNotAfterKey,
+					Help: "Expiry of the certificate presented by the host, in seconds since the epoch",
+				},
+				[]string{cepLabel, nameSpaces, instance, issuer},
 			),
 		},
 	}
@@ -118,30 +129,45 @@ func (m *MetricsInfo) RecordCeps(ns string) {
 }
 
 // RecordCheck updates the total number of checked.
-func (m *MetricsInfo) RecordCheck(epname, ns, instance, probe string) {
+func (m *MetricsInfo) RecordCheck(epname, ns, instance, probe, check string) {
 	if pm, ok := m.metrics[numCheckedKey].(*prometheus.CounterVec); ok {
-		pm.WithLabelValues(epname, ns, instance, probe).Inc()
+		pm.WithLabelValues(epname, ns, instance, probe, check).Inc()
 	}
 }
 
 // RecordFailedCheck updates the total number of successful checked.
-func (m *MetricsInfo) RecordFailedCheck(epname, ns, instance, probe string) {
+func (m *MetricsInfo) RecordFailedCheck(epname, ns, instance, probe, check string) {
 	if pm, ok := m.metrics[numCheckFailedKey].(*prometheus.CounterVec); ok {
-		pm.WithLabelValues(epname, ns, instance, probe).Inc()
+		pm.WithLabelValues(epname, ns, instance, probe, check).Inc()
 	}
 }
 
 // RecordSuccessfulCheck updates the total number of successful checked.
-func (m *MetricsInfo) RecordSuccessfulCheck(epname, ns, instance, probe string) {
+func (m *MetricsInfo) RecordSuccessfulCheck(epname, ns, instance, probe, check string) {
 	if pm, ok := m.metrics[numCheckSuccessfulKey].(*prometheus.CounterVec); ok {
-		pm.WithLabelValues(epname, ns, instance, probe).Inc()
+		pm.WithLabelValues(epname, ns, instance, probe, check).Inc()
 	}
 }
 
 // RecordCheckDuration records the number of seconds taken by a checked.
-func (m *MetricsInfo) RecordCheckDuration(epname, ns, instance, probe string, seconds float64) {
+func (m *MetricsInfo) RecordCheckDuration(epname, ns, instance, probe, check string, seconds float64) {
 	if c, ok := m.metrics[checkDurationSecondsKey].(*prometheus.HistogramVec); ok {
-		c.WithLabelValues(epname, ns, instance, probe).Observe(seconds)
+		c.WithLabelValues(epname, ns, instance, probe, check).Observe(seconds)
+	}
+}
+
+func (m *MetricsInfo) RecordCertificateNotAfter(epname, ns, inst, iss string, notAfter time.Time) {
+	if g, ok := m.metrics[certificateNotAfterKey].(*prometheus.GaugeVec); ok {
+		// Drop the series of the previous issuer of the instance.
+		g.DeletePartialMatch(prometheus.Labels{cepLabel: epname, nameSpaces: ns, instance: inst})
+		g.WithLabelValues(epname, ns, inst, iss).Set(float64(notAfter.Unix()))
+	}
+}
+
+// DeleteCertificateNotAfter drops the series of the instance, so that no alert fires for hosts that are gone.
+func (m *MetricsInfo) DeleteCertificateNotAfter(epname, ns, inst string) {
+	if g, ok := m.metrics[certificateNotAfterKey].(*prometheus.GaugeVec); ok {
+		g.DeletePartialMatch(prometheus.Labels{cepLabel: epname, nameSpaces: ns, instance: inst})
 	}
 }
 
@@ -22,6 +22,10 @@ const (
 	TCP  ProbeType = "tcp"
 	EXEC ProbeType = "exec"
 	UDP  ProbeType = "udp"
+
+	MYSQL      ProbeType = "mysql"
+	POSTGRESQL ProbeType = "postgresql"
+	REDIS      ProbeType = "redis"
 )
 
 type Point struct {